@@ -9,62 +9,47 @@ import (
 	"github.com/aws/aws-sdk-go/service/sqs"
 )
 
+// PublishWithContext publishes the input with the default publisher
 func PublishWithContext(ctx context.Context, publishInput *sns.PublishInput) error {
-	awsSession, err := BuildSession()
+	publisher, err := DefaultPublisher()
 	if err != nil {
 		return err
 	}
-	svc := sns.New(awsSession)
 
-	_, err = svc.PublishWithContext(ctx, publishInput)
+	_, err = publisher.Publish(ctx, publishInput)
 	return err
-
 }
 
 // PublishMessageToSNS to AWS sns topic
 func PublishMessageToSNS(topicName string, message string, msgData map[string]*sns.MessageAttributeValue) error {
-	awsSession, err := BuildSession()
-	if err != nil {
-		return err
-	}
-	svc := sns.New(awsSession)
-
-	userCreatedTopic := GetSNSArn(topicName)
-
-	pubMessage := &sns.PublishInput{
-		MessageAttributes: msgData,
-		Message:           aws.String(message),
-		TopicArn:          aws.String(userCreatedTopic),
-	}
-
-	_, err = svc.Publish(pubMessage)
+	publisher, err := DefaultPublisher()
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return publishMessage(context.Background(), publisher, publisher.TopicArn(topicName), message, msgData)
 }
 
 // PublishMessageToSNS to AWS sns topic ARN
 func PublishMessageToSNSByARN(topicArn string, message string, msgData map[string]*sns.MessageAttributeValue) error {
-	awsSession, err := BuildSession()
+	publisher, err := DefaultPublisher()
 	if err != nil {
 		return err
 	}
-	svc := sns.New(awsSession)
 
+	return publishMessage(context.Background(), publisher, topicArn, message, msgData)
+}
+
+// publishMessage publishes a message with attributes to a topic ARN
+func publishMessage(ctx context.Context, publisher Publisher, topicArn string, message string, msgData map[string]*sns.MessageAttributeValue) error {
 	pubMessage := &sns.PublishInput{
 		MessageAttributes: msgData,
 		Message:           aws.String(message),
 		TopicArn:          aws.String(topicArn),
 	}
 
-	_, err = svc.PublishWithContext(context.Background(), pubMessage)
-	if err != nil {
-		return err
-	}
-
-	return nil
+	_, err := publisher.Publish(ctx, pubMessage)
+	return err
 }
 
 // ReceiveMessages to retrieve message from  AWS sqs
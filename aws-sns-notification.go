@@ -35,13 +35,24 @@ type SNSNotification struct {
 // maxSNSMessageSize defines the maximum size in bytes for an SNS message (256KB)
 const maxSNSMessageSize = 256 * 1024
 
-// Send publishes the notification to SNS after validating the message
+// Send publishes the notification with the default publisher after validating the message
 // Returns an error if validation fails or the publish fails
 func (w *SNSNotification) Send(ctx context.Context) error {
+	publisher, err := DefaultPublisher()
+	if err != nil {
+		return err
+	}
+	return w.SendWith(ctx, publisher)
+}
+
+// SendWith publishes the notification with the given publisher after validating the message
+// Returns an error if validation fails or the publish fails
+func (w *SNSNotification) SendWith(ctx context.Context, publisher Publisher) error {
 	if err := w.validate(); err != nil {
 		return err
 	}
-	return PublishWithContext(ctx, w.build())
+	_, err := publisher.Publish(ctx, w.build(w.getTopic(publisher)))
+	return err
 }
 
 // parseBody serializes the Body field to JSON if present
@@ -73,11 +84,11 @@ func (w *SNSNotification) parseRecipients() error {
 }
 
 // build creates SNS message attributes from the notification
-// Returns an SNS PublishInput with the notification data and attributes
-func (w *SNSNotification) build() *sns.PublishInput {
+// Returns an SNS PublishInput for the topic ARN with the notification data and attributes
+func (w *SNSNotification) build(topicArn string) *sns.PublishInput {
 	attributes := make(map[string]*sns.MessageAttributeValue)
 	input := &sns.PublishInput{
-		TopicArn: aws.String(w.getTopic()),
+		TopicArn: aws.String(topicArn),
 		Message:  aws.String(w.Message),
 	}
 
@@ -136,6 +147,15 @@ func (w *SNSNotification) build() *sns.PublishInput {
 // validateMessageSize checks if the total message size is within SNS limits
 // Returns an error if the message exceeds maxSNSMessageSize
 func (w *SNSNotification) validateMessageSize() error {
+	msgSize := w.messageSize()
+	if msgSize > maxSNSMessageSize {
+		return fmt.Errorf("notification exceeds maximum SNS message size of %d bytes (current: %d)", maxSNSMessageSize, msgSize)
+	}
+	return nil
+}
+
+// messageSize estimates the published size of the message including its attributes
+func (w *SNSNotification) messageSize() int {
 	var bodySize, recipientsSize int
 
 	if w.Body != nil {
@@ -161,11 +181,7 @@ func (w *SNSNotification) validateMessageSize() error {
 		}
 	}
 
-	msgSize := len(w.Message) + len(w.Subject) + attributeSize
-	if msgSize > maxSNSMessageSize {
-		return fmt.Errorf("notification exceeds maximum SNS message size of %d bytes (current: %d)", maxSNSMessageSize, msgSize)
-	}
-	return nil
+	return len(w.Message) + len(w.Subject) + attributeSize
 }
 
 // validate checks if all required fields are present and valid
@@ -195,8 +211,8 @@ func (w *SNSNotification) validate() error {
 }
 
 // getTopic returns the full SNS ARN for the notification's topic
-// by calling GetSNSArn with the Topic field value.
+// by asking the publisher to resolve the Topic field value.
 // This converts the topic name into a complete SNS topic ARN.
-func (w *SNSNotification) getTopic() string {
-	return GetSNSArn(w.Topic)
+func (w *SNSNotification) getTopic(publisher Publisher) string {
+	return publisher.TopicArn(w.Topic)
 }
@@ -71,6 +71,16 @@ func (cache *RedisCache) Set(key string, value interface{}, exp time.Duration) e
 	return nil
 }
 
+// SetNX create a new item in redis with expiry only if the key does not exist
+func (cache *RedisCache) SetNX(key string, value interface{}, exp time.Duration) (bool, error) {
+	return cache.client.SetNX(key, value, exp).Result()
+}
+
+// SetEx create a new item in redis with expiry, returning connection failures as errors
+func (cache *RedisCache) SetEx(key string, value interface{}, exp time.Duration) error {
+	return cache.client.Set(key, value, exp).Err()
+}
+
 // Get a item by key from redis
 func (cache *RedisCache) Get(key string) (string, error) {
 
@@ -126,6 +136,20 @@ func (cache *InMemoryCache) Set(key string, value interface{}, exp time.Duration
 	return nil
 }
 
+// SetNX create a new item in memory only if the key does not exist
+func (cache *InMemoryCache) SetNX(key string, value interface{}, exp time.Duration) (bool, error) {
+	if err := cache.client.Add(key, value, exp); err != nil {
+		return false, nil
+	}
+	return true, nil
+}
+
+// SetEx create a new item in memory with expiry
+func (cache *InMemoryCache) SetEx(key string, value interface{}, exp time.Duration) error {
+	cache.client.Set(key, value, exp)
+	return nil
+}
+
 func (cache *InMemoryCache) Get(key string) (string, error) {
 
 	val, found := cache.client.Get(key)
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS sns_outbox (
+    id              CHAR(36)     NOT NULL,
+    topic           VARCHAR(256) NOT NULL,
+    notification    JSON         NOT NULL,
+    status          VARCHAR(16)  NOT NULL,
+    attempts        BIGINT       NOT NULL DEFAULT 0,
+    next_attempt_at DATETIME(3)  NOT NULL,
+    claim_token     CHAR(36)     NULL,
+    last_error      TEXT,
+    message_id      VARCHAR(100),
+    created_at      DATETIME(3)  NOT NULL,
+    sent_at         DATETIME(3)  NULL,
+    PRIMARY KEY (id),
+    INDEX idx_sns_outbox_pending (status, next_attempt_at),
+    INDEX idx_sns_outbox_claim_token (claim_token),
+    INDEX idx_sns_outbox_sent_at (sent_at)
+);
@@ -1,6 +1,7 @@
 package pkgcommon
 
 import (
+	"context"
 	"encoding/json"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -18,7 +19,17 @@ type ServiceAlert struct {
 	Service        string
 }
 
+// ServiceAlertNotification publishes a service alert with the default publisher
 func ServiceAlertNotification(notification ServiceAlert) error {
+	publisher, err := DefaultPublisher()
+	if err != nil {
+		return err
+	}
+	return ServiceAlertNotificationWith(context.Background(), publisher, notification)
+}
+
+// ServiceAlertNotificationWith publishes a service alert with the given publisher
+func ServiceAlertNotificationWith(ctx context.Context, publisher Publisher, notification ServiceAlert) error {
 	data := []byte("")
 	if notification.Data != nil {
 		data, _ = json.Marshal(notification.Data)
@@ -60,6 +71,6 @@ func ServiceAlertNotification(notification ServiceAlert) error {
 		}
 	}
 
-	return PublishMessageToSNS(notification.TopicName, "Service alert", msgData)
+	return publishMessage(ctx, publisher, publisher.TopicArn(notification.TopicName), "Service alert", msgData)
 
 }
@@ -0,0 +1,169 @@
+package pkgcommon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+)
+
+// maxSNSBatchEntries is the maximum number of messages in one PublishBatch call
+const maxSNSBatchEntries = 10
+
+// BatchPublishResult is the outcome of publishing one notification of a batch
+type BatchPublishResult struct {
+	Notification *SNSNotification
+	MessageID    string
+	Err          error
+}
+
+// BatchPublishReport holds the results of a batch in the order the notifications were given
+type BatchPublishReport struct {
+	Results []BatchPublishResult
+}
+
+// BatchEntryError is the failure SNS reported for a single entry of a PublishBatch call
+type BatchEntryError struct {
+	Code        string
+	Message     string
+	SenderFault bool
+}
+
+func (e *BatchEntryError) Error() string {
+	return fmt.Sprintf("publish batch entry failed: %s: %s", e.Code, e.Message)
+}
+
+// Failed returns the notifications that were not published, for retrying
+func (r *BatchPublishReport) Failed() []*SNSNotification {
+	var failed []*SNSNotification
+	for _, result := range r.Results {
+		if result.Err != nil {
+			failed = append(failed, result.Notification)
+		}
+	}
+	return failed
+}
+
+// Err joins the errors of all failed notifications. Returns nil when every notification was published
+func (r *BatchPublishReport) Err() error {
+	var errs []error
+	for i, result := range r.Results {
+		if result.Err != nil {
+			errs = append(errs, fmt.Errorf("notification %d: %w", i, result.Err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// SendBatch publishes notifications for the same topic with the default publisher
+func SendBatch(ctx context.Context, notifications []*SNSNotification) (*BatchPublishReport, error) {
+	publisher, err := DefaultPublisher()
+	if err != nil {
+		return nil, err
+	}
+	return SendBatchWith(ctx, publisher, notifications)
+}
+
+// SendBatchWith publishes notifications for the same topic using PublishBatch calls of up to
+// 10 messages that stay within the SNS size limit. Notifications that fail validation are
+// reported without being sent. Returns an error only when the notifications target different topics
+func SendBatchWith(ctx context.Context, publisher Publisher, notifications []*SNSNotification) (*BatchPublishReport, error) {
+	report := &BatchPublishReport{Results: make([]BatchPublishResult, len(notifications))}
+	if len(notifications) == 0 {
+		return report, nil
+	}
+
+	for _, n := range notifications[1:] {
+		if n.Topic != notifications[0].Topic || n.IsFIFO != notifications[0].IsFIFO {
+			return nil, errors.New("all notifications of a batch must target the same topic")
+		}
+	}
+	topicArn := notifications[0].getTopic(publisher)
+
+	var chunk []int
+	var chunkSize int
+	for i, n := range notifications {
+		report.Results[i].Notification = n
+		if err := n.validate(); err != nil {
+			report.Results[i].Err = err
+			continue
+		}
+
+		size := n.messageSize()
+		if len(chunk) == maxSNSBatchEntries || (len(chunk) > 0 && chunkSize+size > maxSNSMessageSize) {
+			publishChunk(ctx, publisher, topicArn, chunk, report)
+			chunk, chunkSize = nil, 0
+		}
+		chunk = append(chunk, i)
+		chunkSize += size
+	}
+	if len(chunk) > 0 {
+		publishChunk(ctx, publisher, topicArn, chunk, report)
+	}
+	return report, nil
+}
+
+// publishChunk publishes the notifications at the given indexes in one PublishBatch call
+// and records the outcome of each in the report
+func publishChunk(ctx context.Context, publisher Publisher, topicArn string, indexes []int, report *BatchPublishReport) {
+	input := &sns.PublishBatchInput{TopicArn: aws.String(topicArn)}
+	for _, i := range indexes {
+		single := report.Results[i].Notification.build(topicArn)
+		input.PublishBatchRequestEntries = append(input.PublishBatchRequestEntries, &sns.PublishBatchRequestEntry{
+			Id:                     aws.String(strconv.Itoa(i)),
+			Message:                single.Message,
+			Subject:                single.Subject,
+			MessageAttributes:      single.MessageAttributes,
+			MessageGroupId:         single.MessageGroupId,
+			MessageDeduplicationId: single.MessageDeduplicationId,
+		})
+	}
+
+	output, err := publisher.PublishBatch(ctx, input)
+	if err != nil {
+		for _, i := range indexes {
+			report.Results[i].Err = err
+		}
+		return
+	}
+
+	reported := make(map[int]bool, len(indexes))
+	for _, entry := range output.Successful {
+		if i, ok := batchEntryIndex(entry.Id, indexes); ok {
+			report.Results[i].MessageID = aws.StringValue(entry.MessageId)
+			reported[i] = true
+		}
+	}
+	for _, entry := range output.Failed {
+		if i, ok := batchEntryIndex(entry.Id, indexes); ok {
+			report.Results[i].Err = &BatchEntryError{
+				Code:        aws.StringValue(entry.Code),
+				Message:     aws.StringValue(entry.Message),
+				SenderFault: aws.BoolValue(entry.SenderFault),
+			}
+			reported[i] = true
+		}
+	}
+	for _, i := range indexes {
+		if !reported[i] {
+			report.Results[i].Err = errors.New("publish batch response did not include the entry")
+		}
+	}
+}
+
+// batchEntryIndex returns the notification index of a batch entry ID, if it belongs to the chunk
+func batchEntryIndex(id *string, indexes []int) (int, bool) {
+	i, err := strconv.Atoi(aws.StringValue(id))
+	if err != nil {
+		return 0, false
+	}
+	for _, index := range indexes {
+		if index == i {
+			return i, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,217 @@
+package pkgcommon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+)
+
+// batchRecorder is a LocalBroker that records the messages of every PublishBatch call.
+// Entries whose message is in fail are reported as failed, entries in drop are left out
+// of the response, and err fails whole calls
+type batchRecorder struct {
+	*LocalBroker
+	fail  map[string]bool
+	drop  map[string]bool
+	err   error
+	mu    sync.Mutex
+	calls [][]string
+}
+
+func newBatchRecorder(t *testing.T, topic string) *batchRecorder {
+	t.Helper()
+
+	broker := NewLocalBroker(nil)
+	broker.CreateTopic(topic)
+	broker.Queues().CreateQueue(topic+"-queue", MemoryQueueOptions{})
+	if _, err := broker.Subscribe(topic, topic+"-queue", LocalSubscriptionOptions{RawMessageDelivery: true}); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	return &batchRecorder{LocalBroker: broker}
+}
+
+func (p *batchRecorder) PublishBatch(ctx context.Context, input *sns.PublishBatchInput) (*sns.PublishBatchOutput, error) {
+	var messages []string
+	forward := &sns.PublishBatchInput{TopicArn: input.TopicArn}
+	var failed []*sns.BatchResultErrorEntry
+	for _, entry := range input.PublishBatchRequestEntries {
+		message := aws.StringValue(entry.Message)
+		messages = append(messages, message)
+		switch {
+		case p.fail[message]:
+			failed = append(failed, &sns.BatchResultErrorEntry{
+				Id:          entry.Id,
+				Code:        aws.String(sns.ErrCodeInvalidParameterException),
+				Message:     aws.String("rejected"),
+				SenderFault: aws.Bool(true),
+			})
+		case !p.drop[message]:
+			forward.PublishBatchRequestEntries = append(forward.PublishBatchRequestEntries, entry)
+		}
+	}
+
+	p.mu.Lock()
+	p.calls = append(p.calls, messages)
+	p.mu.Unlock()
+
+	if p.err != nil {
+		return nil, p.err
+	}
+	output := &sns.PublishBatchOutput{}
+	if len(forward.PublishBatchRequestEntries) > 0 {
+		var err error
+		if output, err = p.LocalBroker.PublishBatch(ctx, forward); err != nil {
+			return nil, err
+		}
+	}
+	output.Failed = append(output.Failed, failed...)
+	return output, nil
+}
+
+// callSizes returns the number of entries of every PublishBatch call
+func (p *batchRecorder) callSizes() []int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var sizes []int
+	for _, call := range p.calls {
+		sizes = append(sizes, len(call))
+	}
+	return sizes
+}
+
+// testNotifications returns n service-to-service notifications for topic with messages of size bytes
+func testNotifications(topic string, n, size int) []*SNSNotification {
+	notifications := make([]*SNSNotification, n)
+	for i := range notifications {
+		message := fmt.Sprintf("message-%d", i)
+		if size > len(message) {
+			message += strings.Repeat("x", size-len(message))
+		}
+		notifications[i] = &SNSNotification{Topic: topic, Message: message, IsServiceToService: true}
+	}
+	return notifications
+}
+
+func TestSendBatchWith(t *testing.T) {
+	tests := []struct {
+		name          string
+		notifications []*SNSNotification
+		fail          []int
+		drop          []int
+		err           error
+		wantCalls     []int
+		wantFailed    []int
+		wantFailure   string
+	}{
+		{
+			name:          "eleven notifications take two calls",
+			notifications: testNotifications("orders", 11, 0),
+			wantCalls:     []int{10, 1},
+		},
+		{
+			name:          "oversize aggregate is split",
+			notifications: testNotifications("orders", 3, 100*1024),
+			wantCalls:     []int{2, 1},
+		},
+		{
+			name:          "failed entry is the only failure",
+			notifications: testNotifications("orders", 4, 0),
+			fail:          []int{2},
+			wantCalls:     []int{4},
+			wantFailed:    []int{2},
+			wantFailure:   "rejected",
+		},
+		{
+			name:          "entry missing from the response fails",
+			notifications: testNotifications("orders", 3, 0),
+			drop:          []int{0},
+			wantCalls:     []int{3},
+			wantFailed:    []int{0},
+			wantFailure:   "did not include the entry",
+		},
+		{
+			name:          "failed call fails every entry of the chunk",
+			notifications: testNotifications("orders", 12, 0),
+			err:           errors.New("service unavailable"),
+			wantCalls:     []int{10, 2},
+			wantFailed:    []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+			wantFailure:   "service unavailable",
+		},
+		{
+			name: "invalid notification is not sent",
+			notifications: append(testNotifications("orders", 2, 0),
+				&SNSNotification{Topic: "orders", Message: "no recipients"}),
+			wantCalls:   []int{2},
+			wantFailed:  []int{2},
+			wantFailure: "recipient",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			publisher := newBatchRecorder(t, "orders")
+			publisher.fail = make(map[string]bool)
+			publisher.drop = make(map[string]bool)
+			for _, i := range tt.fail {
+				publisher.fail[tt.notifications[i].Message] = true
+			}
+			for _, i := range tt.drop {
+				publisher.drop[tt.notifications[i].Message] = true
+			}
+			publisher.err = tt.err
+
+			report, err := SendBatchWith(context.Background(), publisher, tt.notifications)
+			if err != nil {
+				t.Fatalf("SendBatchWith: %v", err)
+			}
+			if got := publisher.callSizes(); !slices.Equal(got, tt.wantCalls) {
+				t.Errorf("PublishBatch calls with %v entries, want %v", got, tt.wantCalls)
+			}
+
+			var wantFailed []*SNSNotification
+			for _, i := range tt.wantFailed {
+				wantFailed = append(wantFailed, tt.notifications[i])
+			}
+			if got := report.Failed(); !slices.Equal(got, wantFailed) {
+				t.Errorf("Failed() returned %d notifications, want %d", len(got), len(wantFailed))
+			}
+			for i, result := range report.Results {
+				if result.Err != nil {
+					if !strings.Contains(result.Err.Error(), tt.wantFailure) {
+						t.Errorf("notification %d failed with %v, want %q", i, result.Err, tt.wantFailure)
+					}
+					continue
+				}
+				if result.MessageID == "" {
+					t.Errorf("published notification %d has no message ID", i)
+				}
+			}
+			if visible, _ := queueDepth(t, publisher.Queues(), "orders-queue"); visible != len(tt.notifications)-len(tt.wantFailed) {
+				t.Errorf("%d messages delivered, want %d", visible, len(tt.notifications)-len(tt.wantFailed))
+			}
+			if (report.Err() != nil) != (len(tt.wantFailed) > 0) {
+				t.Errorf("Err() = %v, want an error for %d failures", report.Err(), len(tt.wantFailed))
+			}
+		})
+	}
+}
+
+func TestSendBatchWithRejectsMixedTopics(t *testing.T) {
+	publisher := newBatchRecorder(t, "orders")
+	notifications := append(testNotifications("orders", 1, 0), testNotifications("payments", 1, 0)...)
+
+	if _, err := SendBatchWith(context.Background(), publisher, notifications); err == nil {
+		t.Error("SendBatchWith accepted notifications for different topics")
+	}
+	if got := publisher.callSizes(); len(got) != 0 {
+		t.Errorf("PublishBatch called %d times, want none", len(got))
+	}
+}
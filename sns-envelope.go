@@ -0,0 +1,105 @@
+package pkgcommon
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// ErrNotSNSEnvelope is returned when a message body is not an SNS notification envelope,
+// as is the case for raw message delivery subscriptions
+var ErrNotSNSEnvelope = errors.New("message body is not an SNS envelope")
+
+// SNSEnvelope is the JSON document SNS wraps around a notification delivered to SQS
+type SNSEnvelope struct {
+	Type              string                         `json:"Type"`
+	MessageID         string                         `json:"MessageId"`
+	TopicArn          string                         `json:"TopicArn"`
+	Subject           string                         `json:"Subject,omitempty"`
+	Message           string                         `json:"Message"`
+	Timestamp         time.Time                      `json:"Timestamp"`
+	SignatureVersion  string                         `json:"SignatureVersion,omitempty"`
+	Signature         string                         `json:"Signature,omitempty"`
+	SigningCertURL    string                         `json:"SigningCertURL,omitempty"`
+	UnsubscribeURL    string                         `json:"UnsubscribeURL,omitempty"`
+	MessageAttributes map[string]SNSMessageAttribute `json:"MessageAttributes,omitempty"`
+}
+
+// SNSMessageAttribute is a message attribute inside an SNS envelope.
+// Binary values are base64 encoded
+type SNSMessageAttribute struct {
+	Type  string `json:"Type"`
+	Value string `json:"Value"`
+}
+
+// DecodeSNSEnvelope parses an SQS message body as an SNS envelope.
+// Returns ErrNotSNSEnvelope when the body was delivered raw
+func DecodeSNSEnvelope(body string) (*SNSEnvelope, error) {
+	var envelope SNSEnvelope
+	if err := json.Unmarshal([]byte(body), &envelope); err != nil {
+		return nil, ErrNotSNSEnvelope
+	}
+	if envelope.Type == "" || envelope.TopicArn == "" {
+		return nil, ErrNotSNSEnvelope
+	}
+	return &envelope, nil
+}
+
+// Attribute returns the value of an envelope message attribute
+func (e *SNSEnvelope) Attribute(name string) (string, bool) {
+	attr, ok := e.MessageAttributes[name]
+	return attr.Value, ok
+}
+
+// sqsMessageAttributes converts the envelope attributes to SQS message attributes
+func (e *SNSEnvelope) sqsMessageAttributes() map[string]types.MessageAttributeValue {
+	attributes := make(map[string]types.MessageAttributeValue, len(e.MessageAttributes))
+	for name, attr := range e.MessageAttributes {
+		value := types.MessageAttributeValue{DataType: aws.String(attr.Type)}
+		if attr.Type == "Binary" {
+			b, err := base64.StdEncoding.DecodeString(attr.Value)
+			if err != nil {
+				continue
+			}
+			value.BinaryValue = b
+		} else {
+			value.StringValue = aws.String(attr.Value)
+		}
+		attributes[name] = value
+	}
+	return attributes
+}
+
+type snsEnvelopeKey struct{}
+
+// SNSEnvelopeFromContext returns the SNS envelope unwrapped from the message being processed.
+// It is only present when the subscriber unwraps envelopes and the message was not delivered raw
+func SNSEnvelopeFromContext(ctx context.Context) (*SNSEnvelope, bool) {
+	envelope, ok := ctx.Value(snsEnvelopeKey{}).(*SNSEnvelope)
+	return envelope, ok
+}
+
+// unwrapSNSEnvelope returns a copy of msg whose body is the SNS notification message and
+// whose attributes include the envelope attributes. Raw deliveries are returned unchanged
+func unwrapSNSEnvelope(ctx context.Context, msg *types.Message) (context.Context, *types.Message) {
+	if msg.Body == nil {
+		return ctx, msg
+	}
+	envelope, err := DecodeSNSEnvelope(*msg.Body)
+	if err != nil {
+		return ctx, msg
+	}
+
+	unwrapped := *msg
+	unwrapped.Body = aws.String(envelope.Message)
+	unwrapped.MessageAttributes = envelope.sqsMessageAttributes()
+	for name, value := range msg.MessageAttributes {
+		unwrapped.MessageAttributes[name] = value
+	}
+	return context.WithValue(ctx, snsEnvelopeKey{}, envelope), &unwrapped
+}
@@ -0,0 +1,251 @@
+package pkgcommon
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/sns"
+)
+
+// filterPolicy is a parsed SNS subscription filter policy on message attributes.
+// Every attribute must match one of its conditions
+type filterPolicy map[string][]filterCondition
+
+// filterCondition reports whether an attribute matches. attr is nil when the message does not carry it
+type filterCondition func(attr *sns.MessageAttributeValue) bool
+
+// parseFilterPolicy parses a filter policy JSON document. Supported conditions are exact
+// strings and numbers, prefix, suffix, equals-ignore-case, anything-but, numeric and exists
+func parseFilterPolicy(policy string) (filterPolicy, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(policy), &raw); err != nil {
+		return nil, fmt.Errorf("invalid filter policy: %w", err)
+	}
+
+	parsed := make(filterPolicy, len(raw))
+	for name, value := range raw {
+		var rules []json.RawMessage
+		if err := json.Unmarshal(value, &rules); err != nil {
+			return nil, fmt.Errorf("invalid filter policy: attribute %s must be a list of conditions", name)
+		}
+		for _, rule := range rules {
+			condition, err := parseFilterCondition(rule)
+			if err != nil {
+				return nil, fmt.Errorf("invalid filter policy: attribute %s: %w", name, err)
+			}
+			parsed[name] = append(parsed[name], condition)
+		}
+	}
+	return parsed, nil
+}
+
+// matches reports whether the message attributes satisfy the policy
+func (p filterPolicy) matches(attributes map[string]*sns.MessageAttributeValue) bool {
+	for name, conditions := range p {
+		attr := attributes[name]
+		matched := false
+		for _, condition := range conditions {
+			if condition(attr) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// parseFilterCondition parses a single condition of an attribute's list
+func parseFilterCondition(rule json.RawMessage) (filterCondition, error) {
+	var value any
+	if err := json.Unmarshal(rule, &value); err != nil {
+		return nil, err
+	}
+
+	switch v := value.(type) {
+	case string:
+		return func(attr *sns.MessageAttributeValue) bool {
+			return anyString(attr, func(s string) bool { return s == v })
+		}, nil
+	case float64:
+		return func(attr *sns.MessageAttributeValue) bool {
+			return anyNumber(attr, func(n float64) bool { return n == v })
+		}, nil
+	case map[string]any:
+		if len(v) != 1 {
+			return nil, fmt.Errorf("condition must have exactly one operator")
+		}
+		for operator, operand := range v {
+			return parseFilterOperator(operator, operand)
+		}
+	}
+	return nil, fmt.Errorf("unsupported condition %s", string(rule))
+}
+
+// parseFilterOperator parses an operator condition such as {"prefix": "car-"}
+func parseFilterOperator(operator string, operand any) (filterCondition, error) {
+	switch operator {
+	case "prefix", "suffix", "equals-ignore-case":
+		s, ok := operand.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s requires a string", operator)
+		}
+		match := map[string]func(string) bool{
+			"prefix":             func(v string) bool { return strings.HasPrefix(v, s) },
+			"suffix":             func(v string) bool { return strings.HasSuffix(v, s) },
+			"equals-ignore-case": func(v string) bool { return strings.EqualFold(v, s) },
+		}[operator]
+		return func(attr *sns.MessageAttributeValue) bool { return anyString(attr, match) }, nil
+
+	case "exists":
+		exists, ok := operand.(bool)
+		if !ok {
+			return nil, fmt.Errorf("exists requires a boolean")
+		}
+		return func(attr *sns.MessageAttributeValue) bool { return (attr != nil) == exists }, nil
+
+	case "anything-but":
+		excluded, err := parseAnythingBut(operand)
+		if err != nil {
+			return nil, err
+		}
+		return func(attr *sns.MessageAttributeValue) bool {
+			values := attributeValues(attr)
+			if len(values) == 0 {
+				return false
+			}
+			for _, value := range values {
+				if excluded(value) {
+					return false
+				}
+			}
+			return true
+		}, nil
+
+	case "numeric":
+		inRange, err := parseNumericRange(operand)
+		if err != nil {
+			return nil, err
+		}
+		return func(attr *sns.MessageAttributeValue) bool { return anyNumber(attr, inRange) }, nil
+	}
+	return nil, fmt.Errorf("unsupported operator %s", operator)
+}
+
+// parseAnythingBut returns a func reporting whether a value is excluded by an anything-but operand
+func parseAnythingBut(operand any) (func(value any) bool, error) {
+	switch v := operand.(type) {
+	case string, float64:
+		return func(value any) bool { return value == v }, nil
+	case []any:
+		return func(value any) bool {
+			for _, excluded := range v {
+				if value == excluded {
+					return true
+				}
+			}
+			return false
+		}, nil
+	case map[string]any:
+		prefix, ok := v["prefix"].(string)
+		if !ok || len(v) != 1 {
+			return nil, fmt.Errorf("anything-but only supports a prefix operator")
+		}
+		return func(value any) bool {
+			s, ok := value.(string)
+			return ok && strings.HasPrefix(s, prefix)
+		}, nil
+	}
+	return nil, fmt.Errorf("anything-but requires a value, a list or a prefix")
+}
+
+// parseNumericRange parses a numeric operand such as [">", 0, "<=", 5]
+func parseNumericRange(operand any) (func(n float64) bool, error) {
+	terms, ok := operand.([]any)
+	if !ok || len(terms) == 0 || len(terms)%2 != 0 {
+		return nil, fmt.Errorf("numeric requires operator and value pairs")
+	}
+
+	var checks []func(n float64) bool
+	for i := 0; i < len(terms); i += 2 {
+		op, ok := terms[i].(string)
+		bound, isNumber := terms[i+1].(float64)
+		if !ok || !isNumber {
+			return nil, fmt.Errorf("numeric requires operator and value pairs")
+		}
+		switch op {
+		case "=":
+			checks = append(checks, func(n float64) bool { return n == bound })
+		case "<":
+			checks = append(checks, func(n float64) bool { return n < bound })
+		case "<=":
+			checks = append(checks, func(n float64) bool { return n <= bound })
+		case ">":
+			checks = append(checks, func(n float64) bool { return n > bound })
+		case ">=":
+			checks = append(checks, func(n float64) bool { return n >= bound })
+		default:
+			return nil, fmt.Errorf("unsupported numeric operator %s", op)
+		}
+	}
+
+	return func(n float64) bool {
+		for _, check := range checks {
+			if !check(n) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+// attributeValues returns the values of an attribute as strings and float64s.
+// String.Array attributes yield their elements; Binary attributes never match
+func attributeValues(attr *sns.MessageAttributeValue) []any {
+	if attr == nil || attr.StringValue == nil {
+		return nil
+	}
+
+	dataType := stringValue(attr.DataType)
+	switch {
+	case strings.HasPrefix(dataType, "Number"):
+		n, err := strconv.ParseFloat(*attr.StringValue, 64)
+		if err != nil {
+			return nil
+		}
+		return []any{n}
+	case dataType == "String.Array":
+		var values []any
+		if err := json.Unmarshal([]byte(*attr.StringValue), &values); err != nil {
+			return nil
+		}
+		return values
+	case strings.HasPrefix(dataType, "String"):
+		return []any{*attr.StringValue}
+	}
+	return nil
+}
+
+// anyString reports whether one of the attribute's string values matches
+func anyString(attr *sns.MessageAttributeValue, match func(string) bool) bool {
+	for _, value := range attributeValues(attr) {
+		if s, ok := value.(string); ok && match(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// anyNumber reports whether one of the attribute's numeric values matches
+func anyNumber(attr *sns.MessageAttributeValue, match func(float64) bool) bool {
+	for _, value := range attributeValues(attr) {
+		if n, ok := value.(float64); ok && match(n) {
+			return true
+		}
+	}
+	return false
+}
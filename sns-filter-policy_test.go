@@ -0,0 +1,111 @@
+package pkgcommon
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+)
+
+// stringAttr, numberAttr and arrayAttr build SNS message attributes of each data type
+func stringAttr(value string) *sns.MessageAttributeValue {
+	return &sns.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(value)}
+}
+
+func numberAttr(value string) *sns.MessageAttributeValue {
+	return &sns.MessageAttributeValue{DataType: aws.String("Number"), StringValue: aws.String(value)}
+}
+
+func arrayAttr(value string) *sns.MessageAttributeValue {
+	return &sns.MessageAttributeValue{DataType: aws.String("String.Array"), StringValue: aws.String(value)}
+}
+
+func TestFilterPolicyMatches(t *testing.T) {
+	tests := []struct {
+		name       string
+		policy     string
+		attributes map[string]*sns.MessageAttributeValue
+		want       bool
+	}{
+		{"exact string", `{"type":["order"]}`, map[string]*sns.MessageAttributeValue{"type": stringAttr("order")}, true},
+		{"exact string mismatch", `{"type":["order"]}`, map[string]*sns.MessageAttributeValue{"type": stringAttr("payment")}, false},
+		{"one of several strings", `{"type":["order","payment"]}`, map[string]*sns.MessageAttributeValue{"type": stringAttr("payment")}, true},
+		{"missing attribute", `{"type":["order"]}`, nil, false},
+		{"exact number", `{"price":[100]}`, map[string]*sns.MessageAttributeValue{"price": numberAttr("100.0")}, true},
+		{"number does not match a string", `{"price":["100"]}`, map[string]*sns.MessageAttributeValue{"price": numberAttr("100")}, false},
+		{"prefix", `{"car":[{"prefix":"car-"}]}`, map[string]*sns.MessageAttributeValue{"car": stringAttr("car-1")}, true},
+		{"prefix mismatch", `{"car":[{"prefix":"car-"}]}`, map[string]*sns.MessageAttributeValue{"car": stringAttr("bike-1")}, false},
+		{"suffix", `{"file":[{"suffix":".png"}]}`, map[string]*sns.MessageAttributeValue{"file": stringAttr("a.png")}, true},
+		{"equals ignore case", `{"type":[{"equals-ignore-case":"ORDER"}]}`, map[string]*sns.MessageAttributeValue{"type": stringAttr("Order")}, true},
+		{"anything but value", `{"type":[{"anything-but":"order"}]}`, map[string]*sns.MessageAttributeValue{"type": stringAttr("payment")}, true},
+		{"anything but excluded value", `{"type":[{"anything-but":"order"}]}`, map[string]*sns.MessageAttributeValue{"type": stringAttr("order")}, false},
+		{"anything but list", `{"type":[{"anything-but":["order","payment"]}]}`, map[string]*sns.MessageAttributeValue{"type": stringAttr("payment")}, false},
+		{"anything but number", `{"price":[{"anything-but":100}]}`, map[string]*sns.MessageAttributeValue{"price": numberAttr("100")}, false},
+		{"anything but prefix", `{"type":[{"anything-but":{"prefix":"test-"}}]}`, map[string]*sns.MessageAttributeValue{"type": stringAttr("test-order")}, false},
+		{"anything but missing attribute", `{"type":[{"anything-but":"order"}]}`, nil, false},
+		{"numeric range", `{"price":[{"numeric":[">",0,"<=",100]}]}`, map[string]*sns.MessageAttributeValue{"price": numberAttr("100")}, true},
+		{"numeric range exclusive bound", `{"price":[{"numeric":[">",0,"<",100]}]}`, map[string]*sns.MessageAttributeValue{"price": numberAttr("100")}, false},
+		{"numeric equals", `{"price":[{"numeric":["=",5]}]}`, map[string]*sns.MessageAttributeValue{"price": numberAttr("5")}, true},
+		{"numeric on a string", `{"price":[{"numeric":[">=",0]}]}`, map[string]*sns.MessageAttributeValue{"price": stringAttr("5")}, false},
+		{"exists", `{"type":[{"exists":true}]}`, map[string]*sns.MessageAttributeValue{"type": stringAttr("order")}, true},
+		{"exists on a missing attribute", `{"type":[{"exists":true}]}`, nil, false},
+		{"not exists", `{"type":[{"exists":false}]}`, nil, true},
+		{"not exists on a present attribute", `{"type":[{"exists":false}]}`, map[string]*sns.MessageAttributeValue{"type": stringAttr("order")}, false},
+		{"string array element", `{"tags":["vip"]}`, map[string]*sns.MessageAttributeValue{"tags": arrayAttr(`["new","vip"]`)}, true},
+		{"string array without the element", `{"tags":["vip"]}`, map[string]*sns.MessageAttributeValue{"tags": arrayAttr(`["new"]`)}, false},
+		{"string array anything but", `{"tags":[{"anything-but":"blocked"}]}`, map[string]*sns.MessageAttributeValue{"tags": arrayAttr(`["new","blocked"]`)}, false},
+		{"string array number element", `{"ids":[{"numeric":[">",10]}]}`, map[string]*sns.MessageAttributeValue{"ids": arrayAttr(`[1,20]`)}, true},
+		{
+			name:       "every attribute must match",
+			policy:     `{"type":["order"],"price":[{"numeric":[">",100]}]}`,
+			attributes: map[string]*sns.MessageAttributeValue{"type": stringAttr("order"), "price": numberAttr("50")},
+			want:       false,
+		},
+		{
+			name:       "conditions of an attribute are alternatives",
+			policy:     `{"type":["order",{"prefix":"pay"}]}`,
+			attributes: map[string]*sns.MessageAttributeValue{"type": stringAttr("payment")},
+			want:       true,
+		},
+		{"binary attribute exists", `{"data":[{"exists":true}]}`, map[string]*sns.MessageAttributeValue{"data": {DataType: aws.String("Binary"), BinaryValue: []byte("x")}}, true},
+		{"binary attribute never matches a value", `{"data":["x"]}`, map[string]*sns.MessageAttributeValue{"data": {DataType: aws.String("Binary"), BinaryValue: []byte("x")}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := parseFilterPolicy(tt.policy)
+			if err != nil {
+				t.Fatalf("parseFilterPolicy: %v", err)
+			}
+			if got := policy.matches(tt.attributes); got != tt.want {
+				t.Errorf("matches() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFilterPolicyErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy string
+	}{
+		{"not json", `{"type":`},
+		{"conditions not a list", `{"type":"order"}`},
+		{"unsupported operator", `{"type":[{"cidr":"10.0.0.0/24"}]}`},
+		{"several operators in a condition", `{"type":[{"prefix":"a","suffix":"b"}]}`},
+		{"prefix without a string", `{"type":[{"prefix":1}]}`},
+		{"exists without a boolean", `{"type":[{"exists":"yes"}]}`},
+		{"numeric without pairs", `{"price":[{"numeric":[">"]}]}`},
+		{"numeric with an unknown operator", `{"price":[{"numeric":["!=",1]}]}`},
+		{"anything but with an unsupported operator", `{"type":[{"anything-but":{"suffix":"a"}}]}`},
+		{"boolean condition", `{"type":[true]}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseFilterPolicy(tt.policy); err == nil {
+				t.Errorf("parseFilterPolicy(%s) succeeded, want an error", tt.policy)
+			}
+		})
+	}
+}
@@ -0,0 +1,288 @@
+package pkgcommon
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/gofrs/uuid/v5"
+)
+
+const localTopicArnPrefix = "arn:aws:sns:local:000000000000:"
+
+var _ Publisher = (*LocalBroker)(nil)
+
+// LocalBroker is an in-process SNS Publisher that fans published messages out to MemorySQS queues.
+// Install it as the default publisher in tests so SNSNotification.Send, PublishMessageToSNS and
+// ServiceAlertNotification publish to it, then consume the queues with an SQSSubscriber
+// using WithSQSClient(broker.Queues())
+type LocalBroker struct {
+	queues *MemorySQS
+	topics map[string]*localTopic
+	mu     sync.RWMutex
+}
+
+// LocalSubscriptionOptions configures a subscription of a LocalBroker topic
+type LocalSubscriptionOptions struct {
+	// RawMessageDelivery delivers the message body as is, with SNS attributes as SQS
+	// message attributes, instead of wrapping it in an SNSEnvelope
+	RawMessageDelivery bool
+	// FilterPolicy is an SNS filter policy JSON document applied to message attributes
+	FilterPolicy string
+}
+
+// localTopic is a topic of a LocalBroker
+type localTopic struct {
+	name          string
+	arn           string
+	fifo          bool
+	subscriptions []*localSubscription
+}
+
+// localSubscription delivers a topic's messages to a queue
+type localSubscription struct {
+	arn      string
+	queueURL string
+	raw      bool
+	filter   filterPolicy
+}
+
+// NewLocalBroker creates a broker delivering to queues. A new MemorySQS is used when queues is nil
+func NewLocalBroker(queues *MemorySQS) *LocalBroker {
+	if queues == nil {
+		queues = NewMemorySQS()
+	}
+	return &LocalBroker{
+		queues: queues,
+		topics: make(map[string]*localTopic),
+	}
+}
+
+// Queues returns the MemorySQS the broker delivers to
+func (b *LocalBroker) Queues() *MemorySQS {
+	return b.queues
+}
+
+// TopicArn returns the ARN the broker uses for a topic name
+func (b *LocalBroker) TopicArn(name string) string {
+	return localTopicArnPrefix + name
+}
+
+// CreateTopic creates a topic and returns its ARN. Names ending in .fifo create FIFO topics
+func (b *LocalBroker) CreateTopic(name string) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	arn := b.TopicArn(name)
+	if _, ok := b.topics[arn]; !ok {
+		b.topics[arn] = &localTopic{
+			name: name,
+			arn:  arn,
+			fifo: strings.HasSuffix(name, ".fifo"),
+		}
+	}
+	return arn
+}
+
+// Subscribe delivers messages of a topic to a queue created on the broker's MemorySQS.
+// Returns the subscription ARN
+func (b *LocalBroker) Subscribe(topicName, queueName string, opts LocalSubscriptionOptions) (string, error) {
+	queueURL, err := b.queues.GetQueueUrl(context.Background(), &sqs.GetQueueUrlInput{QueueName: aws.String(queueName)})
+	if err != nil {
+		return "", err
+	}
+
+	subscription := &localSubscription{
+		queueURL: *queueURL.QueueUrl,
+		raw:      opts.RawMessageDelivery,
+	}
+	if opts.FilterPolicy != "" {
+		if subscription.filter, err = parseFilterPolicy(opts.FilterPolicy); err != nil {
+			return "", err
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	topic, ok := b.topics[b.TopicArn(topicName)]
+	if !ok {
+		return "", awserr.New(sns.ErrCodeNotFoundException, fmt.Sprintf("topic %s does not exist", topicName), nil)
+	}
+	if topic.fifo != strings.HasSuffix(queueName, ".fifo") {
+		return "", awserr.New(sns.ErrCodeInvalidParameterException, "FIFO topics can only be subscribed by FIFO queues", nil)
+	}
+	subscription.arn = fmt.Sprintf("%s:%s", topic.arn, uuid.Must(uuid.NewV4()).String())
+	topic.subscriptions = append(topic.subscriptions, subscription)
+	return subscription.arn, nil
+}
+
+// Install makes the broker the default publisher until the returned func is called
+func (b *LocalBroker) Install() (restore func()) {
+	previous := SetDefaultPublisher(b)
+	return func() {
+		restoreDefaultPublisher(b, previous)
+	}
+}
+
+// Publish delivers a message to every subscription of its topic whose filter policy matches
+func (b *LocalBroker) Publish(ctx context.Context, input *sns.PublishInput) (*sns.PublishOutput, error) {
+	if input.Message == nil || *input.Message == "" {
+		return nil, awserr.New(sns.ErrCodeInvalidParameterException, "message is required", nil)
+	}
+
+	b.mu.RLock()
+	topic, ok := b.topics[aws.StringValue(input.TopicArn)]
+	var subscriptions []*localSubscription
+	if ok {
+		subscriptions = append(subscriptions, topic.subscriptions...)
+	}
+	b.mu.RUnlock()
+
+	if !ok {
+		return nil, awserr.New(sns.ErrCodeNotFoundException, fmt.Sprintf("topic %s does not exist", aws.StringValue(input.TopicArn)), nil)
+	}
+
+	messageID := uuid.Must(uuid.NewV4()).String()
+	send := &sqs.SendMessageInput{}
+	if topic.fifo {
+		if aws.StringValue(input.MessageGroupId) == "" {
+			return nil, awserr.New(sns.ErrCodeInvalidParameterException, "FIFO topics require a message group ID", nil)
+		}
+		send.MessageGroupId = input.MessageGroupId
+		send.MessageDeduplicationId = input.MessageDeduplicationId
+		if send.MessageDeduplicationId == nil {
+			sum := sha256.Sum256([]byte(*input.Message))
+			send.MessageDeduplicationId = aws.String(hex.EncodeToString(sum[:]))
+		}
+	}
+
+	var errs []error
+	for _, subscription := range subscriptions {
+		if subscription.filter != nil && !subscription.filter.matches(input.MessageAttributes) {
+			continue
+		}
+
+		delivery := *send
+		delivery.QueueUrl = aws.String(subscription.queueURL)
+		if subscription.raw {
+			delivery.MessageBody = input.Message
+			delivery.MessageAttributes = rawDeliveryAttributes(input.MessageAttributes)
+		} else {
+			body, err := json.Marshal(newLocalEnvelope(topic.arn, messageID, input))
+			if err != nil {
+				return nil, err
+			}
+			delivery.MessageBody = aws.String(string(body))
+		}
+
+		if _, err := b.queues.SendMessage(ctx, &delivery); err != nil {
+			errs = append(errs, fmt.Errorf("deliver to %s: %w", subscription.queueURL, err))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return &sns.PublishOutput{MessageId: aws.String(messageID)}, nil
+}
+
+// PublishBatch publishes every entry like Publish and reports failures per entry
+func (b *LocalBroker) PublishBatch(ctx context.Context, input *sns.PublishBatchInput) (*sns.PublishBatchOutput, error) {
+	switch {
+	case len(input.PublishBatchRequestEntries) == 0:
+		return nil, awserr.New(sns.ErrCodeEmptyBatchRequestException, "batch request contains no entries", nil)
+	case len(input.PublishBatchRequestEntries) > maxSNSBatchEntries:
+		return nil, awserr.New(sns.ErrCodeTooManyEntriesInBatchRequestException, "batch request contains more than 10 entries", nil)
+	}
+
+	ids := make(map[string]bool, len(input.PublishBatchRequestEntries))
+	for _, entry := range input.PublishBatchRequestEntries {
+		if ids[aws.StringValue(entry.Id)] {
+			return nil, awserr.New(sns.ErrCodeBatchEntryIdsNotDistinctException, "batch entry IDs are not distinct", nil)
+		}
+		ids[aws.StringValue(entry.Id)] = true
+	}
+
+	output := &sns.PublishBatchOutput{}
+	for _, entry := range input.PublishBatchRequestEntries {
+		published, err := b.Publish(ctx, &sns.PublishInput{
+			TopicArn:               input.TopicArn,
+			Message:                entry.Message,
+			Subject:                entry.Subject,
+			MessageAttributes:      entry.MessageAttributes,
+			MessageGroupId:         entry.MessageGroupId,
+			MessageDeduplicationId: entry.MessageDeduplicationId,
+		})
+		if err != nil {
+			code := "InternalError"
+			var awsErr awserr.Error
+			if errors.As(err, &awsErr) {
+				code = awsErr.Code()
+			}
+			output.Failed = append(output.Failed, &sns.BatchResultErrorEntry{
+				Id:          entry.Id,
+				Code:        aws.String(code),
+				Message:     aws.String(err.Error()),
+				SenderFault: aws.Bool(code != "InternalError"),
+			})
+			continue
+		}
+		output.Successful = append(output.Successful, &sns.PublishBatchResultEntry{
+			Id:        entry.Id,
+			MessageId: published.MessageId,
+		})
+	}
+	return output, nil
+}
+
+// newLocalEnvelope builds the SNS envelope delivered to non-raw subscriptions
+func newLocalEnvelope(topicArn, messageID string, input *sns.PublishInput) SNSEnvelope {
+	envelope := SNSEnvelope{
+		Type:             "Notification",
+		MessageID:        messageID,
+		TopicArn:         topicArn,
+		Subject:          aws.StringValue(input.Subject),
+		Message:          aws.StringValue(input.Message),
+		Timestamp:        time.Now().UTC(),
+		SignatureVersion: "1",
+	}
+	if len(input.MessageAttributes) > 0 {
+		envelope.MessageAttributes = make(map[string]SNSMessageAttribute, len(input.MessageAttributes))
+		for name, attr := range input.MessageAttributes {
+			value := aws.StringValue(attr.StringValue)
+			if attr.BinaryValue != nil {
+				value = base64.StdEncoding.EncodeToString(attr.BinaryValue)
+			}
+			envelope.MessageAttributes[name] = SNSMessageAttribute{Type: aws.StringValue(attr.DataType), Value: value}
+		}
+	}
+	return envelope
+}
+
+// rawDeliveryAttributes converts SNS message attributes to the SQS attributes of a raw delivery
+func rawDeliveryAttributes(attributes map[string]*sns.MessageAttributeValue) map[string]types.MessageAttributeValue {
+	if len(attributes) == 0 {
+		return nil
+	}
+	converted := make(map[string]types.MessageAttributeValue, len(attributes))
+	for name, attr := range attributes {
+		converted[name] = types.MessageAttributeValue{
+			DataType:    attr.DataType,
+			StringValue: attr.StringValue,
+			BinaryValue: attr.BinaryValue,
+		}
+	}
+	return converted
+}
@@ -0,0 +1,111 @@
+package pkgcommon
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+func TestLocalBrokerToSubscriber(t *testing.T) {
+	tests := []struct {
+		name         string
+		subscription LocalSubscriptionOptions
+		notification SNSNotification
+		delivered    bool
+		wantEnvelope bool
+	}{
+		{
+			name:         "enveloped delivery is unwrapped",
+			notification: SNSNotification{Message: "order created", Subject: "orders", Type: "order.created", IsServiceToService: true},
+			delivered:    true,
+			wantEnvelope: true,
+		},
+		{
+			name:         "raw delivery",
+			subscription: LocalSubscriptionOptions{RawMessageDelivery: true},
+			notification: SNSNotification{Message: "order created", Type: "order.created", IsServiceToService: true},
+			delivered:    true,
+		},
+		{
+			name:         "matching filter policy",
+			subscription: LocalSubscriptionOptions{FilterPolicy: `{"type":[{"prefix":"order."}]}`},
+			notification: SNSNotification{Message: "order created", Type: "order.created", IsServiceToService: true},
+			delivered:    true,
+			wantEnvelope: true,
+		},
+		{
+			name:         "filtered out",
+			subscription: LocalSubscriptionOptions{FilterPolicy: `{"type":["payment.created"]}`},
+			notification: SNSNotification{Message: "order created", Type: "order.created", IsServiceToService: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			broker := NewLocalBroker(nil)
+			broker.CreateTopic("orders")
+			broker.Queues().CreateQueue("orders-queue", MemoryQueueOptions{})
+			if _, err := broker.Subscribe("orders", "orders-queue", tt.subscription); err != nil {
+				t.Fatalf("Subscribe: %v", err)
+			}
+
+			var mu sync.Mutex
+			var received []*types.Message
+			var envelopes []*SNSEnvelope
+			sub := newTestSubscriber(t, broker.Queues(), "orders-queue", 1, WithSNSUnwrap())
+			sub.AddContextHandler(func(ctx context.Context, msg *types.Message) error {
+				mu.Lock()
+				defer mu.Unlock()
+				received = append(received, msg)
+				envelope, _ := SNSEnvelopeFromContext(ctx)
+				envelopes = append(envelopes, envelope)
+				return nil
+			})
+			sub.Start()
+
+			notification := tt.notification
+			notification.Topic = "orders"
+			if err := notification.SendWith(context.Background(), broker); err != nil {
+				t.Fatalf("SendWith: %v", err)
+			}
+
+			if !tt.delivered {
+				time.Sleep(200 * time.Millisecond)
+				mu.Lock()
+				defer mu.Unlock()
+				if len(received) != 0 {
+					t.Errorf("filtered notification was delivered")
+				}
+				return
+			}
+
+			waitFor(t, 3*time.Second, func() bool {
+				mu.Lock()
+				defer mu.Unlock()
+				return len(received) > 0
+			})
+			mu.Lock()
+			defer mu.Unlock()
+
+			msg := received[0]
+			if body := stringValue(msg.Body); body != notification.Message {
+				t.Errorf("handler got body %q, want %q", body, notification.Message)
+			}
+			if got, _ := MessageAttribute(msg, "type"); got != notification.Type {
+				t.Errorf("handler got type attribute %q, want %q", got, notification.Type)
+			}
+			envelope := envelopes[0]
+			if (envelope != nil) != tt.wantEnvelope {
+				t.Fatalf("handler got envelope %v, want an envelope %t", envelope, tt.wantEnvelope)
+			}
+			if envelope != nil {
+				if envelope.TopicArn != broker.TopicArn("orders") || envelope.Subject != notification.Subject {
+					t.Errorf("envelope has topic %s and subject %q, want %s and %q", envelope.TopicArn, envelope.Subject, broker.TopicArn("orders"), notification.Subject)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,444 @@
+package pkgcommon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	defaultOutboxPollInterval    = time.Second
+	defaultOutboxBatchSize       = 50
+	defaultOutboxMaxAttempts     = 10
+	defaultOutboxRetention       = 7 * 24 * time.Hour
+	defaultOutboxCleanupInterval = time.Hour
+	defaultOutboxLease           = time.Minute
+)
+
+// Outbox row statuses
+const (
+	OutboxStatusPending = "pending"
+	OutboxStatusSent    = "sent"
+	// OutboxStatusFailed rows failed permanently or ran out of attempts and are kept for inspection
+	OutboxStatusFailed = "failed"
+)
+
+// SNSOutboxMessage is a notification stored in the sns_outbox table until the relay publishes it
+type SNSOutboxMessage struct {
+	ID            string                              `gorm:"primaryKey;type:char(36)"`
+	Topic         string                              `gorm:"type:varchar(256);not null"`
+	Notification  datatypes.JSONType[SNSNotification] `gorm:"not null"`
+	Status        string                              `gorm:"type:varchar(16);not null;index:idx_sns_outbox_pending,priority:1"`
+	Attempts      int                                 `gorm:"not null;default:0"`
+	NextAttemptAt time.Time                           `gorm:"not null;index:idx_sns_outbox_pending,priority:2"`
+	ClaimToken    string                              `gorm:"type:char(36);index:idx_sns_outbox_claim_token"`
+	LastError     string                              `gorm:"type:text"`
+	MessageID     string                              `gorm:"type:varchar(100)"`
+	CreatedAt     time.Time                           `gorm:"not null"`
+	SentAt        *time.Time                          `gorm:"index:idx_sns_outbox_sent_at"`
+}
+
+// TableName returns the outbox table name
+func (SNSOutboxMessage) TableName() string {
+	return "sns_outbox"
+}
+
+// MigrateSNSOutbox creates or updates the sns_outbox table.
+// The same schema is available as SQL in migrations/
+func MigrateSNSOutbox(db *gorm.DB) error {
+	return db.AutoMigrate(&SNSOutboxMessage{})
+}
+
+// EnqueueSNSNotification validates the notification and stores it in the outbox using tx,
+// so it is only published by an OutboxRelay once the caller's transaction commits
+func EnqueueSNSNotification(tx *gorm.DB, notification *SNSNotification) error {
+	if err := notification.validate(); err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	row := SNSOutboxMessage{
+		ID:            uuid.Must(uuid.NewV7()).String(),
+		Topic:         notification.Topic,
+		Notification:  datatypes.NewJSONType(*notification),
+		Status:        OutboxStatusPending,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+	}
+	return tx.Create(&row).Error
+}
+
+// OutboxRelay publishes pending sns_outbox rows, retrying failures with backoff
+// and deleting sent rows after the retention period. Rows are claimed with a lease in
+// a short transaction and published outside it, so several relays can share the table.
+// Delivery is at least once: a row is published again if marking it sent fails or
+// its lease expires before it is marked
+type OutboxRelay struct {
+	db      *gorm.DB
+	opts    outboxOptions
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+	started bool
+	mu      sync.Mutex
+}
+
+// OutboxOption configures an OutboxRelay
+type OutboxOption func(*outboxOptions)
+
+// outboxOptions holds the configuration applied by OutboxOption values
+type outboxOptions struct {
+	publisher       Publisher
+	pollInterval    time.Duration
+	batchSize       int
+	maxAttempts     int
+	backoff         BackoffPolicy
+	retention       time.Duration
+	cleanupInterval time.Duration
+	lease           time.Duration
+	skipLocked      bool
+}
+
+// WithOutboxPublisher publishes with p instead of the default publisher
+func WithOutboxPublisher(p Publisher) OutboxOption {
+	return func(o *outboxOptions) {
+		o.publisher = p
+	}
+}
+
+// WithOutboxPollInterval sets how often the relay looks for pending rows
+func WithOutboxPollInterval(interval time.Duration) OutboxOption {
+	return func(o *outboxOptions) {
+		o.pollInterval = interval
+	}
+}
+
+// WithOutboxBatchSize sets how many rows the relay publishes per round
+func WithOutboxBatchSize(size int) OutboxOption {
+	return func(o *outboxOptions) {
+		o.batchSize = size
+	}
+}
+
+// WithOutboxRetry sets the publish attempts per row and the delay between them
+func WithOutboxRetry(maxAttempts int, backoff BackoffPolicy) OutboxOption {
+	return func(o *outboxOptions) {
+		o.maxAttempts = maxAttempts
+		o.backoff = backoff
+	}
+}
+
+// WithOutboxRetention sets how long sent rows are kept and how often they are cleaned up
+func WithOutboxRetention(retention, cleanupInterval time.Duration) OutboxOption {
+	return func(o *outboxOptions) {
+		o.retention = retention
+		o.cleanupInterval = cleanupInterval
+	}
+}
+
+// WithOutboxLease sets how long claimed rows are reserved for a relay before another relay
+// may publish them. It should cover publishing a batch
+func WithOutboxLease(lease time.Duration) OutboxOption {
+	return func(o *outboxOptions) {
+		o.lease = lease
+	}
+}
+
+// WithOutboxSkipLocked selects rows to claim with SELECT ... FOR UPDATE SKIP LOCKED so
+// relays claiming at the same time do not wait for each other. Requires MySQL 8 or PostgreSQL
+func WithOutboxSkipLocked() OutboxOption {
+	return func(o *outboxOptions) {
+		o.skipLocked = true
+	}
+}
+
+// NewOutboxRelay creates a relay for the sns_outbox table of db
+func NewOutboxRelay(db *gorm.DB, opts ...OutboxOption) (*OutboxRelay, error) {
+	if db == nil {
+		return nil, errors.New("outbox relay requires a database")
+	}
+
+	o := outboxOptions{
+		pollInterval:    defaultOutboxPollInterval,
+		batchSize:       defaultOutboxBatchSize,
+		maxAttempts:     defaultOutboxMaxAttempts,
+		backoff:         DefaultBackoffPolicy(),
+		retention:       defaultOutboxRetention,
+		cleanupInterval: defaultOutboxCleanupInterval,
+		lease:           defaultOutboxLease,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if o.pollInterval <= 0 {
+		return nil, fmt.Errorf("outbox poll interval must be positive")
+	}
+	if o.batchSize <= 0 {
+		return nil, fmt.Errorf("outbox batch size must be positive")
+	}
+	if o.maxAttempts <= 0 {
+		return nil, fmt.Errorf("outbox max attempts must be positive")
+	}
+	if o.retention <= 0 || o.cleanupInterval <= 0 {
+		return nil, fmt.Errorf("outbox retention and cleanup interval must be positive")
+	}
+	if o.lease <= 0 {
+		return nil, fmt.Errorf("outbox lease must be positive")
+	}
+
+	return &OutboxRelay{db: db, opts: o}, nil
+}
+
+// Start runs the relay in the background until Stop is called
+func (r *OutboxRelay) Start() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.started {
+		return errors.New("outbox relay already started")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+	r.started = true
+
+	r.wg.Add(1)
+	go r.run(ctx)
+	return nil
+}
+
+// Stop stops the relay and waits for the current round to finish
+func (r *OutboxRelay) Stop() {
+	r.mu.Lock()
+	if !r.started {
+		r.mu.Unlock()
+		return
+	}
+	r.started = false
+	r.cancel()
+	r.mu.Unlock()
+
+	r.wg.Wait()
+}
+
+// run relays pending rows every poll interval and cleans up sent rows every cleanup interval
+func (r *OutboxRelay) run(ctx context.Context) {
+	defer r.wg.Done()
+
+	poll := time.NewTicker(r.opts.pollInterval)
+	defer poll.Stop()
+	cleanup := time.NewTicker(r.opts.cleanupInterval)
+	defer cleanup.Stop()
+
+	for {
+		// Keep going while full batches are pending
+		for {
+			n, err := r.RelayOnce(ctx)
+			if err != nil && ctx.Err() == nil {
+				log.Printf("Error relaying SNS outbox: %v", err)
+			}
+			if err != nil || n < r.opts.batchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-cleanup.C:
+			if _, err := r.Cleanup(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("Error cleaning up SNS outbox: %v", err)
+			}
+		case <-poll.C:
+		}
+	}
+}
+
+// RelayOnce publishes one batch of due pending rows and returns how many rows it handled
+func (r *OutboxRelay) RelayOnce(ctx context.Context) (int, error) {
+	publisher := r.opts.publisher
+	if publisher == nil {
+		p, err := DefaultPublisher()
+		if err != nil {
+			return 0, err
+		}
+		publisher = p
+	}
+
+	rows, err := r.claim(ctx)
+	if err != nil || len(rows) == 0 {
+		return 0, err
+	}
+
+	db := r.db.WithContext(ctx)
+	for _, batch := range groupOutboxRows(rows) {
+		if err := r.publish(ctx, db, publisher, batch); err != nil {
+			return len(rows), err
+		}
+	}
+	return len(rows), nil
+}
+
+// claim reserves a batch of due pending rows for this relay by moving their next attempt
+// past the lease, and returns them. The transaction only covers selecting and updating
+// the rows; rows another relay claimed first are skipped by the conditional update
+func (r *OutboxRelay) claim(ctx context.Context) ([]SNSOutboxMessage, error) {
+	now := time.Now().UTC()
+	token := uuid.Must(uuid.NewV7()).String()
+
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		query := tx.Model(&SNSOutboxMessage{}).
+			Where("status = ? AND next_attempt_at <= ?", OutboxStatusPending, now).
+			Order("created_at, id").
+			Limit(r.opts.batchSize)
+		if r.opts.skipLocked {
+			query = query.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"})
+		}
+
+		var ids []string
+		if err := query.Pluck("id", &ids).Error; err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+
+		return tx.Model(&SNSOutboxMessage{}).
+			Where("id IN ? AND status = ? AND next_attempt_at <= ?", ids, OutboxStatusPending, now).
+			Updates(map[string]any{
+				"claim_token":     token,
+				"next_attempt_at": now.Add(r.opts.lease),
+			}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []SNSOutboxMessage
+	err = r.db.WithContext(ctx).
+		Where("claim_token = ? AND status = ?", token, OutboxStatusPending).
+		Order("created_at, id").
+		Find(&rows).Error
+	return rows, err
+}
+
+// groupOutboxRows splits rows into batches of the same topic, keeping their order
+func groupOutboxRows(rows []SNSOutboxMessage) [][]SNSOutboxMessage {
+	var batches [][]SNSOutboxMessage
+	index := make(map[string]int)
+	for _, row := range rows {
+		key := fmt.Sprintf("%s|%t", row.Topic, row.Notification.Data().IsFIFO)
+		i, ok := index[key]
+		if !ok {
+			i = len(batches)
+			index[key] = i
+			batches = append(batches, nil)
+		}
+		batches[i] = append(batches[i], row)
+	}
+	return batches
+}
+
+// publish sends a batch of claimed rows for the same topic and records the outcome of each row
+func (r *OutboxRelay) publish(ctx context.Context, db *gorm.DB, publisher Publisher, rows []SNSOutboxMessage) error {
+	var notifications []*SNSNotification
+	var publishable []SNSOutboxMessage
+	for _, row := range rows {
+		notification := row.Notification.Data()
+		if err := notification.validate(); err != nil {
+			// Invalid rows never succeed, so they are not retried
+			if err := r.markFailed(db, row, row.Attempts, err); err != nil {
+				return err
+			}
+			continue
+		}
+		notifications = append(notifications, &notification)
+		publishable = append(publishable, row)
+	}
+	if len(notifications) == 0 {
+		return nil
+	}
+
+	report, err := SendBatchWith(ctx, publisher, notifications)
+	if err != nil {
+		return err
+	}
+
+	for i, result := range report.Results {
+		row := publishable[i]
+		if result.Err == nil {
+			if err := r.markSent(db, row, result.MessageID); err != nil {
+				return err
+			}
+			continue
+		}
+
+		var entryErr *BatchEntryError
+		if errors.As(result.Err, &entryErr) && entryErr.SenderFault {
+			err = r.markFailed(db, row, row.Attempts+1, result.Err)
+		} else {
+			err = r.markRetry(db, row, result.Err)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// claimed selects row while this relay still holds its claim
+func claimed(db *gorm.DB, row SNSOutboxMessage) *gorm.DB {
+	return db.Model(&SNSOutboxMessage{}).Where("id = ? AND claim_token = ?", row.ID, row.ClaimToken)
+}
+
+// markSent records a published row
+func (r *OutboxRelay) markSent(db *gorm.DB, row SNSOutboxMessage, messageID string) error {
+	now := time.Now().UTC()
+	return claimed(db, row).Updates(map[string]any{
+		"status":     OutboxStatusSent,
+		"attempts":   row.Attempts + 1,
+		"message_id": messageID,
+		"last_error": "",
+		"sent_at":    &now,
+	}).Error
+}
+
+// markRetry schedules the next attempt of a row, or fails it once it ran out of attempts
+func (r *OutboxRelay) markRetry(db *gorm.DB, row SNSOutboxMessage, cause error) error {
+	attempts := row.Attempts + 1
+	if attempts >= r.opts.maxAttempts {
+		return r.markFailed(db, row, attempts, fmt.Errorf("giving up after %d attempts: %w", attempts, cause))
+	}
+
+	return claimed(db, row).Updates(map[string]any{
+		"attempts":        attempts,
+		"last_error":      cause.Error(),
+		"next_attempt_at": time.Now().UTC().Add(r.opts.backoff.Delay(attempts)),
+	}).Error
+}
+
+// markFailed stops retrying a row after the given number of publish attempts
+func (r *OutboxRelay) markFailed(db *gorm.DB, row SNSOutboxMessage, attempts int, cause error) error {
+	log.Printf("SNS outbox message %s failed: %v", row.ID, cause)
+	return claimed(db, row).Updates(map[string]any{
+		"status":     OutboxStatusFailed,
+		"attempts":   attempts,
+		"last_error": cause.Error(),
+	}).Error
+}
+
+// Cleanup deletes sent rows older than the retention period and returns how many were deleted
+func (r *OutboxRelay) Cleanup(ctx context.Context) (int64, error) {
+	result := r.db.WithContext(ctx).
+		Where("status = ? AND sent_at < ?", OutboxStatusSent, time.Now().UTC().Add(-r.opts.retention)).
+		Delete(&SNSOutboxMessage{})
+	return result.RowsAffected, result.Error
+}
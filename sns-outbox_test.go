@@ -0,0 +1,297 @@
+package pkgcommon
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"gorm.io/datatypes"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newOutboxDB creates an SQLite database with the sns_outbox table
+func newOutboxDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "outbox.db")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	if err := MigrateSNSOutbox(db); err != nil {
+		t.Fatalf("MigrateSNSOutbox: %v", err)
+	}
+	return db
+}
+
+// outboxRows returns every row of the outbox by message, in enqueue order
+func outboxRows(t *testing.T, db *gorm.DB) map[string]SNSOutboxMessage {
+	t.Helper()
+
+	var rows []SNSOutboxMessage
+	if err := db.Order("created_at, id").Find(&rows).Error; err != nil {
+		t.Fatalf("load outbox rows: %v", err)
+	}
+	byMessage := make(map[string]SNSOutboxMessage, len(rows))
+	for _, row := range rows {
+		byMessage[row.Notification.Data().Message] = row
+	}
+	return byMessage
+}
+
+func TestOutboxRelayOnce(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxAttempts int
+		fail        []string
+		err         error
+		invalid     bool
+		wantStatus  map[string]string
+		wantRetried []string
+	}{
+		{
+			name:       "publishes pending rows",
+			wantStatus: map[string]string{"message-0": OutboxStatusSent, "message-1": OutboxStatusSent, "message-2": OutboxStatusSent},
+		},
+		{
+			name:       "rejected entry fails only its row",
+			fail:       []string{"message-1"},
+			wantStatus: map[string]string{"message-0": OutboxStatusSent, "message-1": OutboxStatusFailed, "message-2": OutboxStatusSent},
+		},
+		{
+			name:        "failed call schedules a retry",
+			err:         errors.New("service unavailable"),
+			wantStatus:  map[string]string{"message-0": OutboxStatusPending, "message-1": OutboxStatusPending, "message-2": OutboxStatusPending},
+			wantRetried: []string{"message-0", "message-1", "message-2"},
+		},
+		{
+			name:        "last attempt fails the row",
+			maxAttempts: 1,
+			err:         errors.New("service unavailable"),
+			wantStatus:  map[string]string{"message-0": OutboxStatusFailed, "message-1": OutboxStatusFailed, "message-2": OutboxStatusFailed},
+		},
+		{
+			name:       "invalid row is failed without publishing",
+			invalid:    true,
+			wantStatus: map[string]string{"message-0": OutboxStatusSent, "message-1": OutboxStatusSent, "message-2": OutboxStatusSent, "": OutboxStatusFailed},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newOutboxDB(t)
+			for _, n := range testNotifications("orders", 3, 0) {
+				if err := EnqueueSNSNotification(db, n); err != nil {
+					t.Fatalf("EnqueueSNSNotification: %v", err)
+				}
+			}
+			if tt.invalid {
+				// Rows written outside EnqueueSNSNotification may not validate
+				now := time.Now().UTC()
+				row := SNSOutboxMessage{
+					ID:            "invalid",
+					Topic:         "orders",
+					Notification:  datatypes.NewJSONType(SNSNotification{Topic: "orders", IsServiceToService: true}),
+					Status:        OutboxStatusPending,
+					NextAttemptAt: now,
+					CreatedAt:     now,
+				}
+				if err := db.Create(&row).Error; err != nil {
+					t.Fatalf("create invalid row: %v", err)
+				}
+			}
+
+			publisher := newBatchRecorder(t, "orders")
+			publisher.fail = make(map[string]bool)
+			for _, message := range tt.fail {
+				publisher.fail[message] = true
+			}
+			publisher.err = tt.err
+			opts := []OutboxOption{WithOutboxPublisher(publisher)}
+			if tt.maxAttempts > 0 {
+				opts = append(opts, WithOutboxRetry(tt.maxAttempts, BackoffPolicy{Base: time.Minute}))
+			}
+			relay, err := NewOutboxRelay(db, opts...)
+			if err != nil {
+				t.Fatalf("NewOutboxRelay: %v", err)
+			}
+
+			handled, err := relay.RelayOnce(context.Background())
+			if err != nil {
+				t.Fatalf("RelayOnce: %v", err)
+			}
+			if handled != len(tt.wantStatus) {
+				t.Errorf("RelayOnce handled %d rows, want %d", handled, len(tt.wantStatus))
+			}
+
+			rows := outboxRows(t, db)
+			for message, want := range tt.wantStatus {
+				row := rows[message]
+				if row.Status != want {
+					t.Errorf("row %q has status %s, want %s", message, row.Status, want)
+				}
+				if want == OutboxStatusSent && (row.MessageID == "" || row.SentAt == nil || row.Attempts != 1) {
+					t.Errorf("sent row %q has message ID %q, sent at %v and %d attempts", message, row.MessageID, row.SentAt, row.Attempts)
+				}
+				if want == OutboxStatusFailed && row.LastError == "" {
+					t.Errorf("failed row %q has no error", message)
+				}
+			}
+			for _, message := range tt.wantRetried {
+				row := rows[message]
+				if row.Attempts != 1 || row.LastError == "" || !row.NextAttemptAt.After(time.Now()) {
+					t.Errorf("retried row %q has %d attempts, error %q and next attempt %v", message, row.Attempts, row.LastError, row.NextAttemptAt)
+				}
+			}
+
+			// Nothing is due after the round
+			if handled, err := relay.RelayOnce(context.Background()); err != nil || handled != 0 {
+				t.Errorf("second RelayOnce handled %d rows with error %v, want none", handled, err)
+			}
+		})
+	}
+}
+
+func TestOutboxRelayLease(t *testing.T) {
+	db := newOutboxDB(t)
+	for _, n := range testNotifications("orders", 3, 0) {
+		if err := EnqueueSNSNotification(db, n); err != nil {
+			t.Fatalf("EnqueueSNSNotification: %v", err)
+		}
+	}
+
+	publisher := newBatchRecorder(t, "orders")
+	first, err := NewOutboxRelay(db, WithOutboxPublisher(publisher), WithOutboxLease(200*time.Millisecond))
+	if err != nil {
+		t.Fatalf("NewOutboxRelay: %v", err)
+	}
+	second, err := NewOutboxRelay(db, WithOutboxPublisher(publisher))
+	if err != nil {
+		t.Fatalf("NewOutboxRelay: %v", err)
+	}
+
+	// The first relay claims the rows and stops before publishing them
+	claimed, err := first.claim(context.Background())
+	if err != nil || len(claimed) != 3 {
+		t.Fatalf("claim returned %d rows with error %v, want 3", len(claimed), err)
+	}
+	if handled, err := second.RelayOnce(context.Background()); err != nil || handled != 0 {
+		t.Fatalf("RelayOnce during the lease handled %d rows with error %v, want none", handled, err)
+	}
+
+	time.Sleep(250 * time.Millisecond)
+	if handled, err := second.RelayOnce(context.Background()); err != nil || handled != 3 {
+		t.Fatalf("RelayOnce after the lease handled %d rows with error %v, want 3", handled, err)
+	}
+
+	// The first relay lost its claim and does not overwrite the outcome
+	if err := first.markRetry(db, claimed[0], errors.New("late failure")); err != nil {
+		t.Fatalf("markRetry: %v", err)
+	}
+	if row := outboxRows(t, db)["message-0"]; row.Status != OutboxStatusSent {
+		t.Errorf("row published by the second relay has status %s, want sent", row.Status)
+	}
+	if got := publisher.callSizes(); !slices.Equal(got, []int{3}) {
+		t.Errorf("PublishBatch calls with %v entries, want [3]", got)
+	}
+}
+
+func TestGroupOutboxRows(t *testing.T) {
+	row := func(id, topic string, fifo bool) SNSOutboxMessage {
+		return SNSOutboxMessage{ID: id, Topic: topic, Notification: datatypes.NewJSONType(SNSNotification{Topic: topic, IsFIFO: fifo})}
+	}
+	tests := []struct {
+		name string
+		rows []SNSOutboxMessage
+		want [][]string
+	}{
+		{name: "no rows"},
+		{
+			name: "single topic",
+			rows: []SNSOutboxMessage{row("1", "orders", false), row("2", "orders", false)},
+			want: [][]string{{"1", "2"}},
+		},
+		{
+			name: "interleaved topics keep their order",
+			rows: []SNSOutboxMessage{row("1", "orders", false), row("2", "payments", false), row("3", "orders", false), row("4", "payments", false)},
+			want: [][]string{{"1", "3"}, {"2", "4"}},
+		},
+		{
+			name: "fifo and standard notifications of a topic are separate",
+			rows: []SNSOutboxMessage{row("1", "orders", false), row("2", "orders", true), row("3", "orders", false)},
+			want: [][]string{{"1", "3"}, {"2"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got [][]string
+			for _, batch := range groupOutboxRows(tt.rows) {
+				var ids []string
+				for _, row := range batch {
+					ids = append(ids, row.ID)
+				}
+				got = append(got, ids)
+			}
+			if !slices.EqualFunc(got, tt.want, slices.Equal[[]string]) {
+				t.Errorf("groupOutboxRows() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOutboxNotificationRoundTrip(t *testing.T) {
+	db := newOutboxDB(t)
+	notification := &SNSNotification{
+		Topic:      "orders",
+		Message:    "order created",
+		Subject:    "orders",
+		Recipients: []string{"a@example.com", "b@example.com"},
+		Body:       map[string]any{"order_id": 42},
+		Type:       "order.created",
+		TypeID:     "order-42",
+		ExtraMessageAttributes: map[string]*sns.MessageAttributeValue{
+			"tenant": {DataType: aws.String("String"), StringValue: aws.String("acme")},
+		},
+	}
+	if err := EnqueueSNSNotification(db, notification); err != nil {
+		t.Fatalf("EnqueueSNSNotification: %v", err)
+	}
+
+	publisher := newBatchRecorder(t, "orders")
+	relay, err := NewOutboxRelay(db, WithOutboxPublisher(publisher))
+	if err != nil {
+		t.Fatalf("NewOutboxRelay: %v", err)
+	}
+	if _, err := relay.RelayOnce(context.Background()); err != nil {
+		t.Fatalf("RelayOnce: %v", err)
+	}
+
+	messages := receiveAll(t, publisher.Queues(), "orders-queue")
+	if len(messages) != 1 {
+		t.Fatalf("delivered %d messages, want 1", len(messages))
+	}
+	if body := stringValue(messages[0].Body); body != "order created" {
+		t.Errorf("delivered message %q, want %q", body, "order created")
+	}
+	want := map[string]string{
+		"recipients": `["a@example.com","b@example.com"]`,
+		"body":       `{"order_id":42}`,
+		"type":       "order.created",
+		"typeId":     "order-42",
+		"tenant":     "acme",
+	}
+	for name, value := range want {
+		if got, _ := MessageAttribute(&messages[0], name); got != value {
+			t.Errorf("attribute %s = %q, want %q", name, got, value)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package pkgcommon
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sns/snsiface"
+)
+
+// Publisher publishes notifications to SNS topics. Implementations must be safe for concurrent use
+type Publisher interface {
+	Publish(ctx context.Context, input *sns.PublishInput) (*sns.PublishOutput, error)
+	PublishBatch(ctx context.Context, input *sns.PublishBatchInput) (*sns.PublishBatchOutput, error)
+	// TopicArn returns the ARN of the topic with the given name
+	TopicArn(name string) string
+}
+
+var _ Publisher = (*SNSPublisher)(nil)
+
+var (
+	defaultPublisher   Publisher
+	defaultPublisherMu sync.Mutex
+)
+
+// SNSPublisher is a Publisher backed by a single long-lived SNS client
+type SNSPublisher struct {
+	client   snsiface.SNSAPI
+	topicArn func(name string) string
+}
+
+// PublisherOption configures an SNSPublisher
+type PublisherOption func(*publisherOptions)
+
+// publisherOptions holds the configuration applied by PublisherOption values
+type publisherOptions struct {
+	client      snsiface.SNSAPI
+	session     *session.Session
+	region      string
+	credentials *credentials.Credentials
+	topicArn    func(name string) string
+}
+
+// WithSNSClient uses an existing SNS client instead of building a session
+func WithSNSClient(client snsiface.SNSAPI) PublisherOption {
+	return func(o *publisherOptions) {
+		o.client = client
+	}
+}
+
+// WithSession builds the SNS client from an existing AWS session
+func WithSession(sess *session.Session) PublisherOption {
+	return func(o *publisherOptions) {
+		o.session = sess
+	}
+}
+
+// WithRegion overrides the AWS_REGION environment variable
+func WithRegion(region string) PublisherOption {
+	return func(o *publisherOptions) {
+		o.region = region
+	}
+}
+
+// WithStaticCredentials overrides the AWS_ACCESS_KEY and AWS_SECRET environment variables
+func WithStaticCredentials(accessKey, secretKey string) PublisherOption {
+	return func(o *publisherOptions) {
+		o.credentials = credentials.NewStaticCredentials(accessKey, secretKey, "")
+	}
+}
+
+// WithTopicResolver overrides how topic names are turned into ARNs. Defaults to the
+// naming of GetSNSArn, with the region, account and APP_ENV read once at creation
+func WithTopicResolver(resolve func(name string) string) PublisherOption {
+	return func(o *publisherOptions) {
+		o.topicArn = resolve
+	}
+}
+
+// NewSNSPublisher creates a publisher. Without options the session is built from the
+// same environment variables as BuildSession, once
+func NewSNSPublisher(opts ...PublisherOption) (*SNSPublisher, error) {
+	var o publisherOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.topicArn == nil {
+		o.topicArn = envTopicResolver(o.topicRegion())
+	}
+
+	client := o.client
+	if client == nil {
+		sess, err := o.buildSession()
+		if err != nil {
+			return nil, err
+		}
+		client = sns.New(sess)
+	}
+
+	return &SNSPublisher{
+		client:   client,
+		topicArn: o.topicArn,
+	}, nil
+}
+
+// topicRegion returns the region of the topics: the configured region,
+// the region of the configured session, or AWS_REGION
+func (o publisherOptions) topicRegion() string {
+	if o.region != "" {
+		return o.region
+	}
+	if o.session != nil && o.session.Config.Region != nil {
+		return *o.session.Config.Region
+	}
+	return os.Getenv("AWS_REGION")
+}
+
+// envTopicResolver resolves topic names like GetSNSArn from the account and APP_ENV
+// read once, instead of reloading .env on every call
+func envTopicResolver(region string) func(name string) string {
+	prefix := fmt.Sprintf("arn:aws:sns:%s:%s:", region, os.Getenv("AWS_ACCOUNT_ID"))
+	if env := os.Getenv("APP_ENV"); env != "" && !isProductionEnv() {
+		prefix += env + "_"
+	}
+	return func(name string) string {
+		return prefix + name
+	}
+}
+
+// buildSession returns the configured session or builds one from the environment
+func (o publisherOptions) buildSession() (*session.Session, error) {
+	if o.session != nil {
+		return o.session, nil
+	}
+
+	creds := GetCredentials()
+	config := aws.Config{
+		Region:      aws.String(creds.Region),
+		Credentials: credentials.NewStaticCredentials(creds.AccessKey, creds.SecretKey, ""),
+	}
+	if o.region != "" {
+		config.Region = aws.String(o.region)
+	}
+	if o.credentials != nil {
+		config.Credentials = o.credentials
+	}
+	return session.NewSessionWithOptions(session.Options{Config: config})
+}
+
+// Publish sends a message to SNS
+func (p *SNSPublisher) Publish(ctx context.Context, input *sns.PublishInput) (*sns.PublishOutput, error) {
+	return p.client.PublishWithContext(ctx, input)
+}
+
+// PublishBatch sends up to 10 messages to SNS in one call
+func (p *SNSPublisher) PublishBatch(ctx context.Context, input *sns.PublishBatchInput) (*sns.PublishBatchOutput, error) {
+	return p.client.PublishBatchWithContext(ctx, input)
+}
+
+// TopicArn returns the ARN of the topic with the given name
+func (p *SNSPublisher) TopicArn(name string) string {
+	return p.topicArn(name)
+}
+
+// SetDefaultPublisher replaces the publisher used by the package level publish functions,
+// SNSNotification.Send and ServiceAlertNotification. Returns the previous publisher
+func SetDefaultPublisher(p Publisher) Publisher {
+	defaultPublisherMu.Lock()
+	defer defaultPublisherMu.Unlock()
+
+	previous := defaultPublisher
+	defaultPublisher = p
+	return previous
+}
+
+// DefaultPublisher returns the default publisher, creating an SNSPublisher from the
+// environment on first use
+func DefaultPublisher() (Publisher, error) {
+	defaultPublisherMu.Lock()
+	defer defaultPublisherMu.Unlock()
+
+	if defaultPublisher == nil {
+		p, err := NewSNSPublisher()
+		if err != nil {
+			return nil, err
+		}
+		defaultPublisher = p
+	}
+	return defaultPublisher, nil
+}
+
+// restoreDefaultPublisher puts back previous if p is still the default publisher
+func restoreDefaultPublisher(p, previous Publisher) {
+	defaultPublisherMu.Lock()
+	defer defaultPublisherMu.Unlock()
+
+	if defaultPublisher == p {
+		defaultPublisher = previous
+	}
+}
@@ -0,0 +1,47 @@
+package pkgcommon
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/sns"
+)
+
+func TestSNSPublisherTopicArn(t *testing.T) {
+	tests := []struct {
+		name   string
+		appEnv string
+		opts   []PublisherOption
+		want   string
+	}{
+		{name: "no environment", want: "arn:aws:sns:eu-west-1:123456789012:orders"},
+		{name: "production", appEnv: "production", want: "arn:aws:sns:eu-west-1:123456789012:orders"},
+		{name: "prod", appEnv: "prod", want: "arn:aws:sns:eu-west-1:123456789012:orders"},
+		{name: "staging", appEnv: "staging", want: "arn:aws:sns:eu-west-1:123456789012:staging_orders"},
+		{name: "region option", opts: []PublisherOption{WithRegion("us-east-1")}, want: "arn:aws:sns:us-east-1:123456789012:orders"},
+		{
+			name: "custom resolver",
+			opts: []PublisherOption{WithTopicResolver(func(name string) string { return "arn:custom:" + name })},
+			want: "arn:custom:orders",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("AWS_REGION", "eu-west-1")
+			t.Setenv("AWS_ACCOUNT_ID", "123456789012")
+			t.Setenv("APP_ENV", tt.appEnv)
+
+			p, err := NewSNSPublisher(append([]PublisherOption{WithSNSClient(&sns.SNS{})}, tt.opts...)...)
+			if err != nil {
+				t.Fatalf("NewSNSPublisher: %v", err)
+			}
+			// The environment is read once when the publisher is created
+			t.Setenv("APP_ENV", "changed")
+			t.Setenv("AWS_ACCOUNT_ID", "000000000000")
+
+			if got := p.TopicArn("orders"); got != tt.want {
+				t.Errorf("TopicArn() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,195 @@
+package pkgcommon
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+const (
+	defaultAutoscaleInterval = 30 * time.Second
+	// idleEmptyReceiveRatio is the share of empty receives above which an empty queue is idle
+	idleEmptyReceiveRatio = 0.9
+)
+
+// AutoscaleOptions configures the worker autoscaler of a subscriber
+type AutoscaleOptions struct {
+	// Min and Max bound the active handler workers
+	Min int
+	Max int
+	// Interval is how often the worker count is adjusted. Defaults to 30 seconds
+	Interval time.Duration
+}
+
+// subscriberStats counts receive calls and handler runs
+type subscriberStats struct {
+	receives      atomic.Int64
+	emptyReceives atomic.Int64
+	handled       atomic.Int64
+	handlerNanos  atomic.Int64
+}
+
+// recordReceive counts a receive call that returned n messages
+func (st *subscriberStats) recordReceive(n int) {
+	st.receives.Add(1)
+	if n == 0 {
+		st.emptyReceives.Add(1)
+	}
+}
+
+// recordHandled counts a handler run that took d
+func (st *subscriberStats) recordHandled(d time.Duration) {
+	st.handled.Add(1)
+	st.handlerNanos.Add(int64(d))
+}
+
+// statsSnapshot is a copy of the counters at one point in time
+type statsSnapshot struct {
+	receives      int64
+	emptyReceives int64
+	handled       int64
+	handlerNanos  int64
+}
+
+// snapshot copies the current counters
+func (st *subscriberStats) snapshot() statsSnapshot {
+	return statsSnapshot{
+		receives:      st.receives.Load(),
+		emptyReceives: st.emptyReceives.Load(),
+		handled:       st.handled.Load(),
+		handlerNanos:  st.handlerNanos.Load(),
+	}
+}
+
+// WithAutoscale grows and shrinks the active handler workers between options.Min and
+// options.Max from the queue depth, the share of empty receives and the handler latency.
+// The worker count given to NewSQSSubscriber is the initial count
+func WithAutoscale(options AutoscaleOptions) SubscriberOption {
+	return func(o *subscriberOptions) {
+		if options.Interval <= 0 {
+			options.Interval = defaultAutoscaleInterval
+		}
+		o.autoscale = &options
+	}
+}
+
+// validateAutoscale checks the autoscale bounds against the worker limits
+func (o subscriberOptions) validateAutoscale(workerCount int) error {
+	if o.autoscale == nil {
+		return nil
+	}
+	a := o.autoscale
+	if a.Min < 1 || a.Max < a.Min || a.Max > o.maxWorkers {
+		return fmt.Errorf("autoscale bounds must satisfy 1 <= min <= max <= %d", o.maxWorkers)
+	}
+	if workerCount < a.Min || workerCount > a.Max {
+		return fmt.Errorf("worker count must be between autoscale min %d and max %d", a.Min, a.Max)
+	}
+	return nil
+}
+
+// maxActiveWorkers returns the number of handler workers to start
+func (s *SQSSubscriber) maxActiveWorkers() int {
+	if s.opts.autoscale != nil {
+		return s.opts.autoscale.Max
+	}
+	return s.workerCount
+}
+
+// ActiveWorkers returns the number of handler workers currently allowed to run
+func (s *SQSSubscriber) ActiveWorkers() int {
+	return int(s.activeWorkers.Load())
+}
+
+// setActiveWorkers resizes the active workers, the dispatch slots and the active pollers
+func (s *SQSSubscriber) setActiveWorkers(n int) {
+	s.activeWorkers.Store(int32(n))
+	s.running.setLimit(n)
+	s.slots.setLimit(n + s.opts.dispatchBuffer)
+	s.polling.setLimit(s.pollerCount(n))
+}
+
+// runAutoscaler adjusts the active workers every interval until polling stops
+func (s *SQSSubscriber) runAutoscaler() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.opts.autoscale.Interval)
+	defer ticker.Stop()
+
+	last := s.stats.snapshot()
+	for {
+		select {
+		case <-s.pollCtx.Done():
+			return
+		case <-ticker.C:
+			current := s.stats.snapshot()
+			s.autoscale(last, current)
+			last = current
+		}
+	}
+}
+
+// autoscale computes the desired workers from the stats gathered since the last tick
+func (s *SQSSubscriber) autoscale(last, current statsSnapshot) {
+	depth, err := s.queueDepth()
+	if err != nil {
+		log.Printf("Error reading queue depth: %v", err)
+		return
+	}
+
+	a := s.opts.autoscale
+	active := s.ActiveWorkers()
+	step := max(active/4, 1)
+
+	receives := current.receives - last.receives
+	emptyRatio := 0.0
+	if receives > 0 {
+		emptyRatio = float64(current.emptyReceives-last.emptyReceives) / float64(receives)
+	}
+
+	desired := active
+	switch {
+	case depth == 0 && emptyRatio >= idleEmptyReceiveRatio:
+		desired = active - step
+	case depth > 0:
+		handled := current.handled - last.handled
+		if handled == 0 {
+			desired = active + step
+			break
+		}
+		// Workers needed to drain the backlog within one interval at the observed latency
+		latency := time.Duration((current.handlerNanos - last.handlerNanos) / handled)
+		needed := int(math.Ceil(float64(depth) * latency.Seconds() / a.Interval.Seconds()))
+		if needed > active {
+			desired = needed
+		} else if needed < active-step {
+			desired = active - step
+		}
+	}
+
+	desired = min(max(desired, a.Min), a.Max)
+	if desired != active {
+		log.Printf("Scaling subscriber %s from %d to %d workers (depth %d, empty receives %.0f%%)", stringValue(s.queueURL), active, desired, depth, emptyRatio*100)
+		s.setActiveWorkers(desired)
+	}
+}
+
+// queueDepth returns the approximate number of visible messages in the queue
+func (s *SQSSubscriber) queueDepth() (int, error) {
+	output, err := s.client.GetQueueAttributes(s.pollCtx, &sqs.GetQueueAttributesInput{
+		QueueUrl: s.queueURL,
+		AttributeNames: []types.QueueAttributeName{
+			types.QueueAttributeNameApproximateNumberOfMessages,
+		},
+	})
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(output.Attributes[string(types.QueueAttributeNameApproximateNumberOfMessages)])
+}
@@ -0,0 +1,92 @@
+package pkgcommon
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"math/rand/v2"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// visibilityCallTimeout bounds a ChangeMessageVisibility call that releases or delays a message
+const visibilityCallTimeout = 10 * time.Second
+
+// RetryableError asks the subscriber to make the message visible again after Delay
+type RetryableError struct {
+	Err   error
+	Delay time.Duration
+}
+
+func (e *RetryableError) Error() string {
+	return fmt.Sprintf("retry after %s: %v", e.Delay, e.Err)
+}
+
+func (e *RetryableError) Unwrap() error {
+	return e.Err
+}
+
+// RetryAfter wraps err so the message is retried after delay
+func RetryAfter(err error, delay time.Duration) error {
+	return &RetryableError{Err: err, Delay: delay}
+}
+
+// BackoffPolicy computes the retry delay of a failed message from its receive count.
+// The delay doubles from Base on every receive up to Max, or the 12 hour SQS limit
+// when Max is not set; Jitter is the fraction of the delay (0-1) that is randomized
+type BackoffPolicy struct {
+	Base   time.Duration
+	Max    time.Duration
+	Jitter float64
+}
+
+// DefaultBackoffPolicy returns a policy starting at 5 seconds and capped at 15 minutes
+func DefaultBackoffPolicy() BackoffPolicy {
+	return BackoffPolicy{
+		Base:   5 * time.Second,
+		Max:    15 * time.Minute,
+		Jitter: 0.2,
+	}
+}
+
+// Delay returns the retry delay for a message received receiveCount times
+func (p BackoffPolicy) Delay(receiveCount int) time.Duration {
+	if p.Base <= 0 {
+		return 0
+	}
+	attempt := max(receiveCount-1, 0)
+
+	delay := float64(p.Base) * math.Pow(2, float64(attempt))
+	if p.Max > 0 && delay > float64(p.Max) {
+		delay = float64(p.Max)
+	}
+	// An uncapped delay would overflow time.Duration; SQS cannot delay longer anyway
+	delay = min(delay, float64(maxVisibilityTimeout))
+
+	jitter := min(max(p.Jitter, 0), 1)
+	delay = delay*(1-jitter) + rand.Float64()*delay*jitter
+	return time.Duration(delay)
+}
+
+// changeVisibility makes msg visible again after delay, rounded up to whole seconds
+func (s *SQSSubscriber) changeVisibility(msg *types.Message, delay time.Duration) {
+	delay = min(max(delay, 0), maxVisibilityTimeout)
+	seconds := int32(math.Ceil(delay.Seconds()))
+
+	// Use a context independent of the subscriber so messages are still released
+	// after a shutdown deadline cancelled the handlers
+	ctx, cancel := context.WithTimeout(context.Background(), visibilityCallTimeout)
+	defer cancel()
+
+	_, err := s.client.ChangeMessageVisibility(ctx, &sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          s.queueURL,
+		ReceiptHandle:     msg.ReceiptHandle,
+		VisibilityTimeout: seconds,
+	})
+	if err != nil {
+		log.Printf("Error changing visibility of message %s: %v", stringValue(msg.MessageId), err)
+	}
+}
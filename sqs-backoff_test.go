@@ -0,0 +1,30 @@
+package pkgcommon
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoffPolicyDelay(t *testing.T) {
+	tests := []struct {
+		name         string
+		policy       BackoffPolicy
+		receiveCount int
+		want         time.Duration
+	}{
+		{"first receive uses base", BackoffPolicy{Base: time.Second, Max: time.Minute}, 1, time.Second},
+		{"doubles per receive", BackoffPolicy{Base: time.Second, Max: time.Minute}, 4, 8 * time.Second},
+		{"capped at max", BackoffPolicy{Base: time.Second, Max: time.Minute}, 10, time.Minute},
+		{"zero base disables backoff", BackoffPolicy{}, 5, 0},
+		{"uncapped stays within SQS limit", BackoffPolicy{Base: time.Second}, 20, maxVisibilityTimeout},
+		{"uncapped does not overflow", BackoffPolicy{Base: time.Second}, 100, maxVisibilityTimeout},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.Delay(tt.receiveCount); got != tt.want {
+				t.Errorf("Delay(%d) = %v, want %v", tt.receiveCount, got, tt.want)
+			}
+		})
+	}
+}
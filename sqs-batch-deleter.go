@@ -0,0 +1,157 @@
+package pkgcommon
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+const (
+	// maxDeleteBatchSize is the SQS limit for entries in a DeleteMessageBatch call
+	maxDeleteBatchSize     = 10
+	defaultDeleteFlush     = 500 * time.Millisecond
+	defaultDeleteAttempts  = 3
+	deleteBatchCallTimeout = 10 * time.Second
+)
+
+// deleteEntry is a receipt handle waiting to be deleted
+type deleteEntry struct {
+	messageID     string
+	receiptHandle *string
+	attempts      int
+}
+
+// batchDeleter buffers receipt handles of processed messages and deletes them
+// with DeleteMessageBatch when the batch fills or the flush interval elapses
+type batchDeleter struct {
+	client        QueueClient
+	queueURL      *string
+	flushInterval time.Duration
+	maxAttempts   int
+	entries       chan deleteEntry
+	done          chan struct{}
+	onDeleted     func(n int)
+}
+
+// newBatchDeleter creates and starts a batchDeleter for the subscriber queue
+func newBatchDeleter(client QueueClient, queueURL *string, flushInterval time.Duration, maxAttempts int, onDeleted func(n int)) *batchDeleter {
+	d := &batchDeleter{
+		onDeleted:     onDeleted,
+		client:        client,
+		queueURL:      queueURL,
+		flushInterval: flushInterval,
+		maxAttempts:   maxAttempts,
+		entries:       make(chan deleteEntry, maxDeleteBatchSize),
+		done:          make(chan struct{}),
+	}
+	go d.run()
+	return d
+}
+
+// Add queues the message for deletion
+func (d *batchDeleter) Add(msg *types.Message) {
+	d.entries <- deleteEntry{
+		messageID:     stringValue(msg.MessageId),
+		receiptHandle: msg.ReceiptHandle,
+	}
+}
+
+// Close flushes all buffered entries and waits for the deleter to finish.
+// Add must not be called after Close
+func (d *batchDeleter) Close() {
+	close(d.entries)
+	<-d.done
+}
+
+func (d *batchDeleter) run() {
+	defer close(d.done)
+
+	ticker := time.NewTicker(d.flushInterval)
+	defer ticker.Stop()
+
+	var buffer []deleteEntry
+	for {
+		select {
+		case entry, ok := <-d.entries:
+			if !ok {
+				// Drain the buffer, retrying failed entries until their attempts run out
+				for len(buffer) > 0 {
+					buffer = d.flush(buffer)
+				}
+				return
+			}
+			buffer = append(buffer, entry)
+			if len(buffer) >= maxDeleteBatchSize {
+				buffer = d.flush(buffer)
+			}
+		case <-ticker.C:
+			if len(buffer) > 0 {
+				buffer = d.flush(buffer)
+			}
+		}
+	}
+}
+
+// flush deletes up to maxDeleteBatchSize entries from the front of the buffer.
+// It returns the remaining buffer with retryable failures appended
+func (d *batchDeleter) flush(buffer []deleteEntry) []deleteEntry {
+	n := min(len(buffer), maxDeleteBatchSize)
+	batch := buffer[:n]
+	rest := append([]deleteEntry(nil), buffer[n:]...)
+
+	input := &sqs.DeleteMessageBatchInput{
+		QueueUrl: d.queueURL,
+		Entries:  make([]types.DeleteMessageBatchRequestEntry, 0, n),
+	}
+	for i, entry := range batch {
+		input.Entries = append(input.Entries, types.DeleteMessageBatchRequestEntry{
+			Id:            aws.String(strconv.Itoa(i)),
+			ReceiptHandle: entry.receiptHandle,
+		})
+	}
+
+	// Use a context independent of the subscriber so a final flush during Stop still completes
+	ctx, cancel := context.WithTimeout(context.Background(), deleteBatchCallTimeout)
+	defer cancel()
+
+	output, err := d.client.DeleteMessageBatch(ctx, input)
+	if err != nil {
+		log.Printf("Error deleting message batch: %v", err)
+		for _, entry := range batch {
+			rest = d.retry(rest, entry)
+		}
+		return rest
+	}
+
+	d.onDeleted(len(output.Successful))
+
+	for _, failed := range output.Failed {
+		i, convErr := strconv.Atoi(stringValue(failed.Id))
+		if convErr != nil || i < 0 || i >= n {
+			continue
+		}
+		entry := batch[i]
+		log.Printf("Error deleting message %s: %s (%s)", entry.messageID, stringValue(failed.Message), stringValue(failed.Code))
+		if failed.SenderFault {
+			// Sender faults such as an expired receipt handle will not succeed on retry
+			continue
+		}
+		rest = d.retry(rest, entry)
+	}
+	return rest
+}
+
+// retry appends entry to buffer if it has attempts left
+func (d *batchDeleter) retry(buffer []deleteEntry, entry deleteEntry) []deleteEntry {
+	entry.attempts++
+	if entry.attempts >= d.maxAttempts {
+		log.Printf("Giving up deleting message %s after %d attempts", entry.messageID, entry.attempts)
+		return buffer
+	}
+	return append(buffer, entry)
+}
@@ -0,0 +1,201 @@
+package pkgcommon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// batchRecordingSQS records the size of every DeleteMessageBatch call and fails the first failCalls of them
+type batchRecordingSQS struct {
+	*MemorySQS
+	mu        sync.Mutex
+	batches   []int
+	failCalls int
+}
+
+func (c *batchRecordingSQS) DeleteMessageBatch(ctx context.Context, params *sqs.DeleteMessageBatchInput, optFns ...func(*sqs.Options)) (*sqs.DeleteMessageBatchOutput, error) {
+	c.mu.Lock()
+	c.batches = append(c.batches, len(params.Entries))
+	fail := len(c.batches) <= c.failCalls
+	c.mu.Unlock()
+
+	if fail {
+		return nil, errors.New("service unavailable")
+	}
+	return c.MemorySQS.DeleteMessageBatch(ctx, params, optFns...)
+}
+
+func (c *batchRecordingSQS) batchSizes() []int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]int(nil), c.batches...)
+}
+
+// receiveN sends n messages to queueName and receives them all
+func receiveN(t *testing.T, mem *MemorySQS, queueName string, n int) []types.Message {
+	t.Helper()
+
+	for i := 0; i < n; i++ {
+		sendTestMessage(t, mem, queueName, fmt.Sprintf("message-%d", i), "")
+	}
+	var messages []types.Message
+	for len(messages) < n {
+		received := receiveAll(t, mem, queueName)
+		if len(received) == 0 {
+			t.Fatalf("received %d of %d messages", len(messages), n)
+		}
+		messages = append(messages, received...)
+	}
+	return messages
+}
+
+func TestBatchDeleter(t *testing.T) {
+	tests := []struct {
+		name          string
+		messages      int
+		flushInterval time.Duration
+		failCalls     int
+		invalidHandle bool
+		// flushed reports whether the messages are deleted before Close
+		flushed     bool
+		wantBatches []int
+		wantDeleted int
+	}{
+		{
+			name:          "full batches are flushed without waiting for the interval",
+			messages:      20,
+			flushInterval: time.Hour,
+			flushed:       true,
+			wantBatches:   []int{10, 10},
+			wantDeleted:   20,
+		},
+		{
+			name:          "partial batch is flushed on the interval",
+			messages:      3,
+			flushInterval: 10 * time.Millisecond,
+			flushed:       true,
+			wantBatches:   []int{3},
+			wantDeleted:   3,
+		},
+		{
+			name:          "close flushes the buffered entries",
+			messages:      13,
+			flushInterval: time.Hour,
+			wantBatches:   []int{10, 3},
+			wantDeleted:   13,
+		},
+		{
+			name:          "failed call is retried",
+			messages:      4,
+			flushInterval: time.Hour,
+			failCalls:     1,
+			wantBatches:   []int{4, 4},
+			wantDeleted:   4,
+		},
+		{
+			name:          "failed call is given up after the attempts",
+			messages:      4,
+			flushInterval: time.Hour,
+			failCalls:     3,
+			wantBatches:   []int{4, 4, 4},
+		},
+		{
+			name:          "invalid receipt handle is not retried",
+			messages:      2,
+			flushInterval: time.Hour,
+			invalidHandle: true,
+			wantBatches:   []int{2},
+			wantDeleted:   1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mem := NewMemorySQS()
+			mem.CreateQueue("orders", MemoryQueueOptions{})
+			messages := receiveN(t, mem, "orders", tt.messages)
+			if tt.invalidHandle {
+				messages[0].ReceiptHandle = aws.String("rh-unknown")
+			}
+
+			client := &batchRecordingSQS{MemorySQS: mem, failCalls: tt.failCalls}
+			var mu sync.Mutex
+			deleted := 0
+			d := newBatchDeleter(client, aws.String(mem.QueueURL("orders")), tt.flushInterval, 3, func(n int) {
+				mu.Lock()
+				defer mu.Unlock()
+				deleted += n
+			})
+			for i := range messages {
+				d.Add(&messages[i])
+			}
+
+			if tt.flushed {
+				waitFor(t, 3*time.Second, func() bool {
+					_, notVisible := queueDepth(t, mem, "orders")
+					return notVisible == 0
+				})
+			}
+			d.Close()
+
+			if got := client.batchSizes(); !slices.Equal(got, tt.wantBatches) {
+				t.Errorf("batch sizes = %v, want %v", got, tt.wantBatches)
+			}
+			if deleted != tt.wantDeleted {
+				t.Errorf("deleted = %d, want %d", deleted, tt.wantDeleted)
+			}
+			if _, notVisible := queueDepth(t, mem, "orders"); notVisible != tt.messages-tt.wantDeleted {
+				t.Errorf("%d messages left on the queue, want %d", notVisible, tt.messages-tt.wantDeleted)
+			}
+		})
+	}
+}
+
+func TestSubscriberBatchesDeletes(t *testing.T) {
+	mem := NewMemorySQS()
+	mem.CreateQueue("orders", MemoryQueueOptions{})
+	for i := 0; i < 25; i++ {
+		sendTestMessage(t, mem, "orders", fmt.Sprintf("message-%d", i), "")
+	}
+
+	client := &batchRecordingSQS{MemorySQS: mem}
+	sub := newTestSubscriber(t, mem, "orders", 5,
+		WithSQSClient(client),
+		WithDeleteBatching(time.Hour, 3),
+	)
+	sub.AddContextHandler(func(ctx context.Context, msg *types.Message) error {
+		return nil
+	})
+	sub.Start()
+
+	waitFor(t, 5*time.Second, func() bool {
+		sizes := client.batchSizes()
+		return len(sizes) >= 2
+	})
+	if err := sub.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+
+	total := 0
+	for _, size := range client.batchSizes() {
+		if size > maxDeleteBatchSize {
+			t.Errorf("batch of %d entries exceeds the SQS limit", size)
+		}
+		total += size
+	}
+	if total != 25 {
+		t.Errorf("deleted %d messages in batches %v, want 25", total, client.batchSizes())
+	}
+	if visible, notVisible := queueDepth(t, mem, "orders"); visible+notVisible != 0 {
+		t.Errorf("queue has %d visible and %d in-flight messages, want none", visible, notVisible)
+	}
+}
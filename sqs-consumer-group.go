@@ -0,0 +1,264 @@
+package pkgcommon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+)
+
+// SQSConsumerGroup consumes several queues from one process with a shared SQS client.
+// The handler workers of the group are shared between queues by weight, so a busy
+// queue cannot starve the others
+type SQSConsumerGroup struct {
+	client      QueueClient
+	concurrency int
+	opts        []SubscriberOption
+	queues      []*groupQueue
+	started     bool
+	mu          sync.Mutex
+}
+
+// groupQueue is a queue registered with a consumer group
+type groupQueue struct {
+	name       string
+	weight     int
+	subscriber *SQSSubscriber
+}
+
+// NewSQSConsumerGroup creates a consumer group with concurrency handler workers in total.
+// The options apply to every queue; the SQS client is created once and shared
+func NewSQSConsumerGroup(concurrency int, opts ...SubscriberOption) (*SQSConsumerGroup, error) {
+	if concurrency <= 0 {
+		return nil, fmt.Errorf("concurrency must be positive")
+	}
+
+	options := defaultSubscriberOptions()
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	client, err := options.sqsClient(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	return &SQSConsumerGroup{
+		client:      client,
+		concurrency: concurrency,
+		opts:        append(append([]SubscriberOption(nil), opts...), WithSQSClient(client)),
+	}, nil
+}
+
+// AddQueue registers a queue with the given weight and returns its subscriber,
+// on which handlers are registered. Queue options override the group options.
+// Every queue needs at least one worker, so a group takes at most concurrency queues
+func (g *SQSConsumerGroup) AddQueue(queueName string, weight int, opts ...SubscriberOption) (*SQSSubscriber, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.started {
+		return nil, fmt.Errorf("cannot add queue %s to a started consumer group", queueName)
+	}
+	if weight <= 0 {
+		return nil, fmt.Errorf("weight of queue %s must be positive", queueName)
+	}
+	for _, q := range g.queues {
+		if q.name == queueName {
+			return nil, fmt.Errorf("queue %s is already registered", queueName)
+		}
+	}
+	if len(g.queues) >= g.concurrency {
+		return nil, fmt.Errorf("cannot add queue %s: concurrency %d is less than the number of queues", queueName, g.concurrency)
+	}
+
+	queueOpts := append(append([]SubscriberOption(nil), g.opts...), opts...)
+	options := defaultSubscriberOptions()
+	for _, opt := range queueOpts {
+		opt(&options)
+	}
+
+	// The worker count is assigned from the queue weight when the group starts
+	initial := 1
+	if options.autoscale != nil {
+		initial = options.autoscale.Min
+	}
+	subscriber, err := NewSQSSubscriber(queueName, initial, queueOpts...)
+	if err != nil {
+		return nil, err
+	}
+
+	g.queues = append(g.queues, &groupQueue{
+		name:       queueName,
+		weight:     weight,
+		subscriber: subscriber,
+	})
+	return subscriber, nil
+}
+
+// Subscriber returns the subscriber of a registered queue
+func (g *SQSConsumerGroup) Subscriber(queueName string) (*SQSSubscriber, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for _, q := range g.queues {
+		if q.name == queueName {
+			return q.subscriber, true
+		}
+	}
+	return nil, false
+}
+
+// Subscribers returns the subscribers of all registered queues, for example for HealthHandler
+func (g *SQSConsumerGroup) Subscribers() []*SQSSubscriber {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	subscribers := make([]*SQSSubscriber, 0, len(g.queues))
+	for _, q := range g.queues {
+		subscribers = append(subscribers, q.subscriber)
+	}
+	return subscribers
+}
+
+// AddHandler registers a handler for a registered queue
+func (g *SQSConsumerGroup) AddHandler(queueName string, handler ContextMessageHandler) error {
+	subscriber, ok := g.Subscriber(queueName)
+	if !ok {
+		return fmt.Errorf("queue %s is not registered", queueName)
+	}
+	subscriber.AddContextHandler(handler)
+	return nil
+}
+
+// Start shares the handler workers between the queues by weight and starts every subscriber
+func (g *SQSConsumerGroup) Start() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.started {
+		return
+	}
+	if g.concurrency < len(g.queues) {
+		log.Printf("Error starting consumer group: concurrency %d is less than the %d queues", g.concurrency, len(g.queues))
+		return
+	}
+
+	weights := make([]int, len(g.queues))
+	for i, q := range g.queues {
+		weights[i] = q.weight
+	}
+
+	for i, workers := range shareWorkers(g.concurrency, weights) {
+		g.queues[i].subscriber.setWorkerCount(workers)
+		g.queues[i].subscriber.Start()
+	}
+	g.started = true
+}
+
+// shareWorkers splits concurrency workers between queues by weight. Every queue gets one
+// worker and the rest are shared by largest remainder, so the shares add up to concurrency
+func shareWorkers(concurrency int, weights []int) []int {
+	shares := make([]int, len(weights))
+	if len(weights) == 0 {
+		return shares
+	}
+
+	totalWeight := 0
+	for _, w := range weights {
+		totalWeight += w
+	}
+
+	spare := concurrency - len(weights)
+	remainders := make([]int, len(weights))
+	order := make([]int, len(weights))
+	assigned := 0
+	for i, w := range weights {
+		shares[i] = 1 + spare*w/totalWeight
+		remainders[i] = spare * w % totalWeight
+		assigned += shares[i]
+		order[i] = i
+	}
+
+	sort.SliceStable(order, func(a, b int) bool {
+		return remainders[order[a]] > remainders[order[b]]
+	})
+	for _, i := range order[:concurrency-assigned] {
+		shares[i]++
+	}
+	return shares
+}
+
+// Stop stops every subscriber and waits for all of them
+func (g *SQSConsumerGroup) Stop() {
+	if err := g.Shutdown(context.Background()); err != nil {
+		log.Printf("Error stopping consumer group: %v", err)
+	}
+}
+
+// Shutdown shuts every subscriber down in parallel, see SQSSubscriber.Shutdown
+func (g *SQSConsumerGroup) Shutdown(ctx context.Context) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if !g.started {
+		return nil
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(g.queues))
+	for i, q := range g.queues {
+		wg.Add(1)
+		go func(i int, subscriber *SQSSubscriber) {
+			defer wg.Done()
+			errs[i] = subscriber.Shutdown(ctx)
+		}(i, q.subscriber)
+	}
+	wg.Wait()
+	g.started = false
+	return errors.Join(errs...)
+}
+
+// Pause stops polling on every queue of the group
+func (g *SQSConsumerGroup) Pause() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for _, q := range g.queues {
+		q.subscriber.Pause()
+	}
+}
+
+// Resume restarts polling on every queue of the group
+func (g *SQSConsumerGroup) Resume() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for _, q := range g.queues {
+		q.subscriber.Resume()
+	}
+}
+
+// Close stops the consumer group
+func (g *SQSConsumerGroup) Close() error {
+	g.Stop()
+	return nil
+}
+
+// setWorkerCount changes the handler workers of a subscriber that is not running,
+// bounded by its maximum worker count or its autoscale bounds
+func (s *SQSSubscriber) setWorkerCount(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.State() != StateStopped {
+		return
+	}
+	if a := s.opts.autoscale; a != nil {
+		s.workerCount = min(max(n, a.Min), a.Max)
+		return
+	}
+	s.workerCount = min(max(n, 1), s.opts.maxWorkers)
+}
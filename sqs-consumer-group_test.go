@@ -0,0 +1,58 @@
+package pkgcommon
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestShareWorkers(t *testing.T) {
+	tests := []struct {
+		name        string
+		concurrency int
+		weights     []int
+		want        []int
+	}{
+		{"single queue", 4, []int{3}, []int{4}},
+		{"equal weights keep the remainder", 10, []int{1, 1, 1}, []int{4, 3, 3}},
+		{"one worker per queue", 5, []int{1, 1, 1, 1, 1}, []int{1, 1, 1, 1, 1}},
+		{"small weight still gets a worker", 4, []int{100, 1}, []int{3, 1}},
+		{"by weight", 12, []int{3, 1}, []int{9, 3}},
+		{"largest remainder wins", 7, []int{1, 2, 4}, []int{2, 2, 3}},
+		{"ties go to the first queue", 6, []int{1, 1, 1, 1}, []int{2, 2, 1, 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := shareWorkers(tt.concurrency, tt.weights)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("shareWorkers(%d, %v) = %v, want %v", tt.concurrency, tt.weights, got, tt.want)
+			}
+			total := 0
+			for _, n := range got {
+				total += n
+			}
+			if total != tt.concurrency {
+				t.Errorf("shares add up to %d, want %d", total, tt.concurrency)
+			}
+		})
+	}
+}
+
+func TestConsumerGroupRejectsMoreQueuesThanWorkers(t *testing.T) {
+	mem := NewMemorySQS()
+	group, err := NewSQSConsumerGroup(2, WithSQSClient(mem), WithAccountID("000000000000"), WithQueueNamePrefix(""))
+	if err != nil {
+		t.Fatalf("NewSQSConsumerGroup: %v", err)
+	}
+
+	for _, name := range []string{"first", "second"} {
+		mem.CreateQueue(name, MemoryQueueOptions{})
+		if _, err := group.AddQueue(name, 1, WithQueueURL(mem.QueueURL(name))); err != nil {
+			t.Fatalf("AddQueue(%s): %v", name, err)
+		}
+	}
+	mem.CreateQueue("third", MemoryQueueOptions{})
+	if _, err := group.AddQueue("third", 1, WithQueueURL(mem.QueueURL("third"))); err == nil {
+		t.Fatal("AddQueue accepted more queues than the group concurrency")
+	}
+}
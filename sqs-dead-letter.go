@@ -0,0 +1,121 @@
+package pkgcommon
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+const (
+	// maxSQSMessageAttributes is the SQS limit for message attributes on a message
+	maxSQSMessageAttributes = 10
+	// maxDeadLetterReasonSize caps the failure reason attached to a dead-lettered message
+	maxDeadLetterReasonSize = 1024
+	deadLetterCallTimeout   = 10 * time.Second
+)
+
+// Message attributes attached to messages moved to a dead-letter queue
+const (
+	DeadLetterReasonAttribute       = "DeadLetterReason"
+	DeadLetterSourceQueueAttribute  = "DeadLetterSourceQueue"
+	DeadLetterReceiveCountAttribute = "DeadLetterReceiveCount"
+	DeadLetterFailedAtAttribute     = "DeadLetterFailedAt"
+	DeadLetterMessageIDAttribute    = "DeadLetterMessageId"
+)
+
+// DeadLetterHandler receives messages that exceeded the receive limit.
+// The message is deleted from the source queue when it returns nil
+type DeadLetterHandler func(ctx context.Context, msg *types.Message, reason error) error
+
+// exceededReceiveLimit reports whether msg has been received at least maxReceiveCount times
+func (s *SQSSubscriber) exceededReceiveLimit(msg *types.Message) bool {
+	return s.opts.deadLetterEnabled() && s.opts.maxReceiveCount > 0 && receiveCount(msg) >= s.opts.maxReceiveCount
+}
+
+// deadLetter hands msg to the dead-letter handler or sends it to the dead-letter queue,
+// then deletes it from the source queue. On failure the message is left on the queue
+func (s *SQSSubscriber) deadLetter(ctx context.Context, msg *types.Message, reason error) {
+	if reason == nil {
+		reason = errors.New("dead-lettered without a reason")
+	}
+
+	var err error
+	if s.opts.deadLetterHandler != nil {
+		err = callRecovered("dead-letter handler", msg, func() error {
+			return s.opts.deadLetterHandler(ctx, msg, reason)
+		})
+	} else {
+		err = s.sendToDeadLetterQueue(msg, reason)
+	}
+	if err != nil {
+		log.Printf("Error dead-lettering message %s: %v", stringValue(msg.MessageId), err)
+		return
+	}
+
+	log.Printf("Dead-lettered message %s after %d receives: %v", stringValue(msg.MessageId), receiveCount(msg), reason)
+	s.deleteMessage(msg)
+}
+
+// sendToDeadLetterQueue copies msg to the dead-letter queue with failure metadata attached
+func (s *SQSSubscriber) sendToDeadLetterQueue(msg *types.Message, reason error) error {
+	failure := reason.Error()
+	if len(failure) > maxDeadLetterReasonSize {
+		failure = failure[:maxDeadLetterReasonSize]
+	}
+
+	metadata := map[string]string{
+		DeadLetterReasonAttribute:       failure,
+		DeadLetterSourceQueueAttribute:  stringValue(s.queueURL),
+		DeadLetterReceiveCountAttribute: strconv.Itoa(receiveCount(msg)),
+		DeadLetterFailedAtAttribute:     time.Now().UTC().Format(time.RFC3339),
+		DeadLetterMessageIDAttribute:    stringValue(msg.MessageId),
+	}
+
+	attributes := make(map[string]types.MessageAttributeValue, maxSQSMessageAttributes)
+	for name, value := range metadata {
+		attributes[name] = types.MessageAttributeValue{
+			DataType:    aws.String("String"),
+			StringValue: aws.String(value),
+		}
+	}
+	// Keep as many original attributes as the SQS limit allows
+	for name, value := range msg.MessageAttributes {
+		if len(attributes) >= maxSQSMessageAttributes {
+			log.Printf("Dropping attribute %s of dead-lettered message %s", name, stringValue(msg.MessageId))
+			continue
+		}
+		if _, exists := attributes[name]; !exists {
+			attributes[name] = value
+		}
+	}
+
+	input := &sqs.SendMessageInput{
+		QueueUrl:          s.opts.deadLetterURL,
+		MessageBody:       msg.Body,
+		MessageAttributes: attributes,
+	}
+	// The dead-letter queue decides whether a group is required, not the source queue.
+	// Messages from a standard queue share a group named after it
+	if isFIFOQueue(stringValue(s.opts.deadLetterURL)) {
+		groupID := messageGroupID(msg)
+		if groupID == "" {
+			groupID = s.queueName
+		}
+		input.MessageGroupId = aws.String(groupID)
+		input.MessageDeduplicationId = msg.MessageId
+	}
+
+	// Use a context independent of the subscriber so the message still moves
+	// after a shutdown deadline cancelled the handlers
+	ctx, cancel := context.WithTimeout(context.Background(), deadLetterCallTimeout)
+	defer cancel()
+
+	_, err := s.client.SendMessage(ctx, input)
+	return err
+}
@@ -0,0 +1,135 @@
+package pkgcommon
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+func TestDeadLetterAfterReceiveLimit(t *testing.T) {
+	tests := []struct {
+		name string
+		// useHandler configures a dead-letter handler returning handlerErr instead of a dead-letter queue
+		useHandler bool
+		handlerErr error
+		wantLeft   bool
+	}{
+		{name: "dead-letter queue"},
+		{name: "dead-letter handler", useHandler: true},
+		{name: "failing dead-letter handler leaves the message", useHandler: true, handlerErr: errors.New("unavailable"), wantLeft: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mem := NewMemorySQS()
+			mem.CreateQueue("orders", MemoryQueueOptions{})
+			mem.CreateQueue("dlq", MemoryQueueOptions{})
+			sendTestMessage(t, mem, "orders", "payload", "")
+
+			var handled atomic.Int32
+			var reason atomic.Value
+			opts := []SubscriberOption{WithDeadLetterQueue("dlq", 3)}
+			if tt.useHandler {
+				opts = []SubscriberOption{WithDeadLetterHandler(3, func(ctx context.Context, msg *types.Message, err error) error {
+					handled.Add(1)
+					reason.Store(err.Error())
+					return tt.handlerErr
+				})}
+			}
+
+			calls := newHandlerCalls()
+			sub := newTestSubscriber(t, mem, "orders", 1, opts...)
+			sub.AddResultHandler(func(ctx context.Context, msg *types.Message) Result {
+				calls.record(msg)
+				return Nack(errors.New("handler failed"))
+			})
+			sub.Start()
+
+			if tt.useHandler {
+				waitFor(t, 5*time.Second, func() bool { return handled.Load() > 0 })
+				if got := reason.Load().(string); !strings.Contains(got, "handler failed") {
+					t.Errorf("dead-letter reason = %q, want the handler error", got)
+				}
+			} else {
+				var dead []types.Message
+				waitFor(t, 5*time.Second, func() bool {
+					dead = append(dead, receiveAll(t, mem, "dlq")...)
+					return len(dead) > 0
+				})
+				attributes := dead[0].MessageAttributes
+				if got := stringValue(attributes[DeadLetterReceiveCountAttribute].StringValue); got != "3" {
+					t.Errorf("dead-letter receive count = %s, want 3", got)
+				}
+				if got := stringValue(attributes[DeadLetterReasonAttribute].StringValue); !strings.Contains(got, "handler failed") {
+					t.Errorf("dead-letter reason = %q, want the handler error", got)
+				}
+				if got := stringValue(attributes[DeadLetterSourceQueueAttribute].StringValue); got != mem.QueueURL("orders") {
+					t.Errorf("dead-letter source queue = %s, want %s", got, mem.QueueURL("orders"))
+				}
+			}
+
+			if tt.wantLeft {
+				time.Sleep(100 * time.Millisecond)
+				if visible, notVisible := queueDepth(t, mem, "orders"); visible+notVisible != 1 {
+					t.Errorf("source queue has %d messages, want the message left on it", visible+notVisible)
+				}
+				return
+			}
+			waitFor(t, 3*time.Second, func() bool {
+				visible, notVisible := queueDepth(t, mem, "orders")
+				return visible+notVisible == 0
+			})
+			if got := calls.calls("payload"); len(got) != 3 {
+				t.Errorf("handler called with receive counts %v, want 3 receives", got)
+			}
+		})
+	}
+}
+
+func TestDeadLetterQueueMessageGroup(t *testing.T) {
+	tests := []struct {
+		name      string
+		source    string
+		dlq       string
+		group     string
+		wantGroup string
+	}{
+		{name: "standard to standard", source: "orders", dlq: "dlq"},
+		{name: "fifo to fifo", source: "orders.fifo", dlq: "dlq.fifo", group: "car-1", wantGroup: "car-1"},
+		{name: "fifo to standard", source: "orders.fifo", dlq: "dlq", group: "car-1"},
+		{name: "standard to fifo", source: "orders", dlq: "dlq.fifo", wantGroup: "orders"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mem := NewMemorySQS()
+			mem.CreateQueue(tt.source, MemoryQueueOptions{})
+			mem.CreateQueue(tt.dlq, MemoryQueueOptions{})
+			sendTestMessage(t, mem, tt.source, "payload", tt.group)
+
+			sub := newTestSubscriber(t, mem, tt.source, 1, WithDeadLetterQueue(tt.dlq, 1))
+			sub.AddResultHandler(func(ctx context.Context, msg *types.Message) Result {
+				return Nack(errors.New("handler failed"))
+			})
+			sub.Start()
+
+			var dead []types.Message
+			waitFor(t, 3*time.Second, func() bool {
+				dead = append(dead, receiveAll(t, mem, tt.dlq)...)
+				return len(dead) > 0
+			})
+			if got := messageGroupID(&dead[0]); got != tt.wantGroup {
+				t.Errorf("dead-lettered message group = %q, want %q", got, tt.wantGroup)
+			}
+			waitFor(t, 3*time.Second, func() bool {
+				visible, notVisible := queueDepth(t, mem, tt.source)
+				return visible+notVisible == 0
+			})
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package pkgcommon
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// dispatchJob is a received message waiting for a handler worker
+type dispatchJob struct {
+	msg        types.Message
+	receivedAt time.Time
+	hb         *heartbeat
+}
+
+// resizableSemaphore is a counting semaphore whose limit can change while it is in use
+type resizableSemaphore struct {
+	limit   int
+	used    int
+	changed chan struct{}
+	mu      sync.Mutex
+}
+
+// newResizableSemaphore creates a semaphore with limit permits
+func newResizableSemaphore(limit int) *resizableSemaphore {
+	return &resizableSemaphore{
+		limit:   limit,
+		changed: make(chan struct{}),
+	}
+}
+
+// acquire blocks until at least one permit is free and takes up to n permits.
+// Returns false when ctx is done first
+func (sem *resizableSemaphore) acquire(ctx context.Context, n int) (int, bool) {
+	for {
+		// A cancelled caller never gets permits, even when some are free
+		if ctx.Err() != nil {
+			return 0, false
+		}
+
+		sem.mu.Lock()
+		if free := sem.limit - sem.used; free > 0 {
+			taken := min(free, n)
+			sem.used += taken
+			sem.mu.Unlock()
+			return taken, true
+		}
+		changed := sem.changed
+		sem.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return 0, false
+		case <-changed:
+		}
+	}
+}
+
+// release returns n permits
+func (sem *resizableSemaphore) release(n int) {
+	if n <= 0 {
+		return
+	}
+
+	sem.mu.Lock()
+	defer sem.mu.Unlock()
+
+	sem.used -= n
+	sem.notify()
+}
+
+// setLimit changes the number of permits. Permits in use above a lower limit
+// are kept until they are released
+func (sem *resizableSemaphore) setLimit(limit int) {
+	sem.mu.Lock()
+	defer sem.mu.Unlock()
+
+	sem.limit = limit
+	sem.notify()
+}
+
+// notify wakes every waiter. The caller must hold mu
+func (sem *resizableSemaphore) notify() {
+	close(sem.changed)
+	sem.changed = make(chan struct{})
+}
+
+// startPool creates the semaphores and starts the handler workers, or the FIFO lanes.
+// Every received message holds a dispatch slot until it is processed, so pollers stop
+// receiving when the active workers and the dispatch buffer are saturated
+func (s *SQSSubscriber) startPool() {
+	workers := s.maxActiveWorkers()
+	capacity := workers + s.opts.dispatchBuffer
+
+	s.activeWorkers.Store(int32(s.workerCount))
+	s.slots = newResizableSemaphore(s.workerCount + s.opts.dispatchBuffer)
+	s.running = newResizableSemaphore(s.workerCount)
+	s.polling = newResizableSemaphore(s.pollerCount(s.workerCount))
+
+	if s.fifo {
+		s.startFIFOLanes(workers, capacity)
+		return
+	}
+
+	s.jobs = make(chan dispatchJob, capacity)
+	for i := 0; i < workers; i++ {
+		s.poolWG.Add(1)
+		go s.runHandlerWorker()
+	}
+}
+
+// stopPool closes the dispatch channels and waits for the workers once no poller can send to them
+func (s *SQSSubscriber) stopPool() {
+	if s.fifo {
+		s.stopFIFOLanes()
+		return
+	}
+
+	close(s.jobs)
+	s.poolWG.Wait()
+	s.jobs = nil
+}
+
+func (s *SQSSubscriber) runHandlerWorker() {
+	defer s.poolWG.Done()
+
+	s.workersAlive.Add(1)
+	defer s.workersAlive.Add(-1)
+
+	for job := range s.jobs {
+		s.runJob(&job.msg, job.receivedAt, job.hb)
+		s.slots.release(1)
+	}
+}
+
+// runJob processes a message once an active worker permit is free. Messages that
+// are not started before shutdown are released so another consumer picks them up,
+// and messages whose visibility lapsed while waiting are skipped because SQS may
+// already have redelivered them
+func (s *SQSSubscriber) runJob(msg *types.Message, receivedAt time.Time, hb *heartbeat) Result {
+	if s.draining.Load() {
+		s.releaseMessage(msg, hb)
+		return Nack(nil)
+	}
+	if _, ok := s.running.acquire(s.ctx, 1); !ok {
+		s.releaseMessage(msg, hb)
+		return Nack(s.ctx.Err())
+	}
+	defer s.running.release(1)
+
+	if expiry := s.visibilityExpiry(receivedAt); time.Now().After(expiry) {
+		hb.Stop()
+		log.Printf("Skipping message %s: visibility expired while it waited for a worker", stringValue(msg.MessageId))
+		return Leave(fmt.Errorf("visibility of message %s expired at %s before processing", stringValue(msg.MessageId), expiry.Format(time.RFC3339)))
+	}
+
+	return s.processMessage(msg, receivedAt, hb)
+}
+
+// acquireSlots blocks until at least one dispatch slot is free and then takes as many
+// free slots as a receive call can fill. Returns false when the subscriber is stopping
+func (s *SQSSubscriber) acquireSlots() (int, bool) {
+	return s.slots.acquire(s.pollCtx, int(s.opts.maxMessages))
+}
+
+// releaseSlots frees n dispatch slots
+func (s *SQSSubscriber) releaseSlots(n int) {
+	s.slots.release(n)
+}
+
+// dispatch hands received messages to the handler workers or the FIFO lanes
+func (s *SQSSubscriber) dispatch(messages []types.Message, receivedAt time.Time) {
+	if s.fifo {
+		s.dispatchFIFO(messages, receivedAt)
+		return
+	}
+
+	// The jobs channel has a buffer for every slot, so sending never blocks.
+	// Visibility is extended from now on, while the message waits for a worker
+	for _, msg := range messages {
+		s.jobs <- dispatchJob{msg: msg, receivedAt: receivedAt, hb: s.startHeartbeat(&msg, receivedAt)}
+	}
+}
+
+// releaseMessage stops extending the visibility of a message that will not be processed
+// and makes it visible again immediately
+func (s *SQSSubscriber) releaseMessage(msg *types.Message, hb *heartbeat) {
+	hb.Stop()
+	if msg.ReceiptHandle != nil {
+		s.changeVisibility(msg, 0)
+	}
+}
@@ -0,0 +1,104 @@
+package pkgcommon
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// handlerCalls records how often each message body reached a handler and with which receive counts
+type handlerCalls struct {
+	mu     sync.Mutex
+	order  []string
+	counts map[string][]int
+}
+
+func newHandlerCalls() *handlerCalls {
+	return &handlerCalls{counts: make(map[string][]int)}
+}
+
+func (c *handlerCalls) record(msg *types.Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	body := stringValue(msg.Body)
+	c.order = append(c.order, body)
+	c.counts[body] = append(c.counts[body], receiveCount(msg))
+}
+
+func (c *handlerCalls) calls(body string) []int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]int(nil), c.counts[body]...)
+}
+
+func (c *handlerCalls) processed() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.order...)
+}
+
+func TestHeartbeatCoversBufferedMessages(t *testing.T) {
+	mem := NewMemorySQS()
+	mem.CreateQueue("orders", MemoryQueueOptions{})
+	bodies := []string{"a", "b", "c", "d", "e"}
+	for _, body := range bodies {
+		sendTestMessage(t, mem, "orders", body, "")
+	}
+
+	calls := newHandlerCalls()
+	sub := newTestSubscriber(t, mem, "orders", 1,
+		WithVisibilityTimeout(1),
+		WithHeartbeat(200*time.Millisecond, time.Minute),
+	)
+	sub.AddContextHandler(func(ctx context.Context, msg *types.Message) error {
+		calls.record(msg)
+		time.Sleep(400 * time.Millisecond)
+		return nil
+	})
+	sub.Start()
+
+	waitFor(t, 10*time.Second, func() bool {
+		visible, notVisible := queueDepth(t, mem, "orders")
+		return visible+notVisible == 0
+	})
+	for _, body := range bodies {
+		if got := calls.calls(body); len(got) != 1 {
+			t.Errorf("message %s processed with receive counts %v, want exactly once", body, got)
+		}
+	}
+}
+
+func TestExpiredBufferedMessagesAreSkipped(t *testing.T) {
+	mem := NewMemorySQS()
+	mem.CreateQueue("orders", MemoryQueueOptions{})
+	sendTestMessage(t, mem, "orders", "slow", "")
+	sendTestMessage(t, mem, "orders", "waiting", "")
+
+	calls := newHandlerCalls()
+	sub := newTestSubscriber(t, mem, "orders", 1,
+		WithVisibilityTimeout(1),
+		WithoutHeartbeat(),
+	)
+	sub.AddContextHandler(func(ctx context.Context, msg *types.Message) error {
+		calls.record(msg)
+		if stringValue(msg.Body) == "slow" && receiveCount(msg) == 1 {
+			time.Sleep(1200 * time.Millisecond)
+		}
+		return nil
+	})
+	sub.Start()
+
+	waitFor(t, 10*time.Second, func() bool {
+		return len(calls.calls("waiting")) > 0
+	})
+	for _, count := range calls.calls("waiting") {
+		if count < 2 {
+			t.Errorf("message processed after its visibility expired: receive count %s", strconv.Itoa(count))
+		}
+	}
+}
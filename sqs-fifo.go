@@ -0,0 +1,117 @@
+package pkgcommon
+
+import (
+	"hash/fnv"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// fifoGroupBatch holds the messages of one message group from a single receive call, in order
+type fifoGroupBatch struct {
+	groupID    string
+	messages   []types.Message
+	heartbeats []*heartbeat
+	receivedAt time.Time
+}
+
+// isFIFOQueue reports whether the queue URL names a FIFO queue
+func isFIFOQueue(queueURL string) bool {
+	return strings.HasSuffix(queueURL, ".fifo")
+}
+
+// messageGroupID returns the MessageGroupId system attribute of msg
+func messageGroupID(msg *types.Message) string {
+	return msg.Attributes[string(types.MessageSystemAttributeNameMessageGroupId)]
+}
+
+// startFIFOLanes starts one lane per handler worker. Every message group is pinned to a lane,
+// so messages of a group are processed in sequence while groups run in parallel
+func (s *SQSSubscriber) startFIFOLanes(workers, capacity int) {
+	s.lanes = make([]chan fifoGroupBatch, workers)
+	for i := range s.lanes {
+		// A lane can hold every message that holds a dispatch slot
+		s.lanes[i] = make(chan fifoGroupBatch, capacity)
+		s.poolWG.Add(1)
+		go s.runFIFOLane(s.lanes[i])
+	}
+}
+
+// stopFIFOLanes closes the lanes and waits for them to finish once no poller can send to them
+func (s *SQSSubscriber) stopFIFOLanes() {
+	for _, lane := range s.lanes {
+		close(lane)
+	}
+	s.poolWG.Wait()
+	s.lanes = nil
+}
+
+// dispatchFIFO splits received messages by message group and sends each group to its lane
+func (s *SQSSubscriber) dispatchFIFO(messages []types.Message, receivedAt time.Time) {
+	var order []string
+	groups := make(map[string]*fifoGroupBatch)
+	for _, msg := range messages {
+		groupID := messageGroupID(&msg)
+		batch, ok := groups[groupID]
+		if !ok {
+			batch = &fifoGroupBatch{groupID: groupID, receivedAt: receivedAt}
+			groups[groupID] = batch
+			order = append(order, groupID)
+		}
+		batch.messages = append(batch.messages, msg)
+		// Every message of the group stays invisible while the messages before it are processed
+		batch.heartbeats = append(batch.heartbeats, s.startHeartbeat(&msg, receivedAt))
+	}
+
+	// Lanes have a buffer for every slot, so sending never blocks
+	for _, groupID := range order {
+		s.lanes[s.laneIndex(groupID)] <- *groups[groupID]
+	}
+}
+
+// laneIndex pins a message group to a lane
+func (s *SQSSubscriber) laneIndex(groupID string) int {
+	h := fnv.New32a()
+	h.Write([]byte(groupID))
+	return int(h.Sum32() % uint32(len(s.lanes)))
+}
+
+func (s *SQSSubscriber) runFIFOLane(lane <-chan fifoGroupBatch) {
+	defer s.poolWG.Done()
+
+	s.workersAlive.Add(1)
+	defer s.workersAlive.Add(-1)
+
+	for batch := range lane {
+		s.processFIFOGroup(batch)
+	}
+}
+
+// processFIFOGroup processes the messages of a group in order. When a message is not acked,
+// the rest of the group is released so it is redelivered after the failed message
+func (s *SQSSubscriber) processFIFOGroup(batch fifoGroupBatch) {
+	defer s.releaseSlots(len(batch.messages))
+
+	for i := range batch.messages {
+		res := s.runJob(&batch.messages[i], batch.receivedAt, batch.heartbeats[i])
+		if res.Action == ActionAck {
+			continue
+		}
+
+		rest := batch.messages[i+1:]
+		if len(rest) > 0 {
+			log.Printf("Releasing %d messages of group %q after message %s was not acked", len(rest), batch.groupID, stringValue(batch.messages[i].MessageId))
+		}
+		s.releaseGroup(rest, batch.heartbeats[i+1:])
+		return
+	}
+}
+
+// releaseGroup makes the remaining messages of a group visible again immediately
+func (s *SQSSubscriber) releaseGroup(messages []types.Message, heartbeats []*heartbeat) {
+	for i := range messages {
+		s.releaseMessage(&messages[i], heartbeats[i])
+	}
+}
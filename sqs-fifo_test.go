@@ -0,0 +1,123 @@
+package pkgcommon
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+func TestFIFOGroupStaysInvisibleWhileWaiting(t *testing.T) {
+	mem := NewMemorySQS()
+	mem.CreateQueue("orders.fifo", MemoryQueueOptions{})
+	bodies := []string{"a", "b", "c", "d", "e"}
+	for _, body := range bodies {
+		sendTestMessage(t, mem, "orders.fifo", body, "car-1")
+	}
+
+	calls := newHandlerCalls()
+	sub := newTestSubscriber(t, mem, "orders.fifo", 2,
+		WithVisibilityTimeout(1),
+		WithHeartbeat(200*time.Millisecond, time.Minute),
+	)
+	sub.AddContextHandler(func(ctx context.Context, msg *types.Message) error {
+		calls.record(msg)
+		time.Sleep(600 * time.Millisecond)
+		return nil
+	})
+	sub.Start()
+
+	waitFor(t, 15*time.Second, func() bool {
+		visible, notVisible := queueDepth(t, mem, "orders.fifo")
+		return visible+notVisible == 0
+	})
+	if got := calls.processed(); !slices.Equal(got, bodies) {
+		t.Errorf("processed %v, want each message once in order %v", got, bodies)
+	}
+}
+
+func TestFIFOGroupOrder(t *testing.T) {
+	type message struct{ body, group string }
+	tests := []struct {
+		name     string
+		messages []message
+		// fail is the body nacked on its first receive
+		fail string
+		want map[string][]string
+	}{
+		{
+			name: "groups are processed in order",
+			messages: []message{
+				{"a1", "a"}, {"b1", "b"}, {"a2", "a"}, {"c1", "c"}, {"b2", "b"}, {"a3", "a"}, {"c2", "c"},
+			},
+			want: map[string][]string{
+				"a": {"a1", "a2", "a3"},
+				"b": {"b1", "b2"},
+				"c": {"c1", "c2"},
+			},
+		},
+		{
+			name: "failed message stops its group",
+			messages: []message{
+				{"a1", "a"}, {"a2", "a"}, {"b1", "b"}, {"a3", "a"}, {"b2", "b"},
+			},
+			fail: "a2",
+			want: map[string][]string{
+				"a": {"a1", "a2", "a2", "a3"},
+				"b": {"b1", "b2"},
+			},
+		},
+		{
+			name: "failed first message stops its group",
+			messages: []message{
+				{"a1", "a"}, {"a2", "a"}, {"a3", "a"},
+			},
+			fail: "a1",
+			want: map[string][]string{
+				"a": {"a1", "a1", "a2", "a3"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mem := NewMemorySQS()
+			mem.CreateQueue("orders.fifo", MemoryQueueOptions{})
+			groups := make(map[string]string)
+			for _, msg := range tt.messages {
+				sendTestMessage(t, mem, "orders.fifo", msg.body, msg.group)
+				groups[msg.body] = msg.group
+			}
+
+			calls := newHandlerCalls()
+			sub := newTestSubscriber(t, mem, "orders.fifo", 3)
+			sub.AddResultHandler(func(ctx context.Context, msg *types.Message) Result {
+				calls.record(msg)
+				time.Sleep(10 * time.Millisecond)
+				if stringValue(msg.Body) == tt.fail && receiveCount(msg) == 1 {
+					return Nack(errors.New("handler failed"))
+				}
+				return Ack()
+			})
+			sub.Start()
+
+			waitFor(t, 5*time.Second, func() bool {
+				visible, notVisible := queueDepth(t, mem, "orders.fifo")
+				return visible+notVisible == 0
+			})
+
+			got := make(map[string][]string)
+			for _, body := range calls.processed() {
+				got[groups[body]] = append(got[groups[body]], body)
+			}
+			for group, want := range tt.want {
+				if !slices.Equal(got[group], want) {
+					t.Errorf("group %s processed %v, want %v", group, got[group], want)
+				}
+			}
+		})
+	}
+}
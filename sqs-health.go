@@ -0,0 +1,101 @@
+package pkgcommon
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// SubscriberHealth reports whether a subscriber is polling its queue
+type SubscriberHealth struct {
+	Queue         string `json:"queue"`
+	State         string `json:"state"`
+	Healthy       bool   `json:"healthy"`
+	PollersAlive  int    `json:"pollers_alive"`
+	WorkersAlive  int    `json:"workers_alive"`
+	ActiveWorkers int    `json:"active_workers"`
+	Backpressured bool   `json:"backpressured"`
+	// LastPollSuccess is nil until a receive call succeeds
+	LastPollSuccess *time.Time `json:"last_poll_success,omitempty"`
+	Reason          string     `json:"reason,omitempty"`
+}
+
+// Health reports the state of the subscriber. A running subscriber is healthy while its
+// pollers and workers are alive and it is either backpressured, with pollers waiting for
+// busy workers or rate limit tokens, or a receive call succeeded within maxPollAge.
+// Until the first receive succeeds, maxPollAge is measured from Start or Resume.
+// A paused subscriber is healthy while its workers are alive
+func (s *SQSSubscriber) Health(maxPollAge time.Duration) SubscriberHealth {
+	health := SubscriberHealth{
+		Queue:         s.queueName,
+		State:         s.State().String(),
+		PollersAlive:  int(s.pollersAlive.Load()),
+		WorkersAlive:  int(s.workersAlive.Load()),
+		ActiveWorkers: s.ActiveWorkers(),
+		Backpressured: s.blocked.Load() > 0,
+	}
+	if last := s.lastPoll.Load(); last > 0 {
+		lastPoll := time.Unix(0, last).UTC()
+		health.LastPollSuccess = &lastPoll
+	}
+
+	switch s.State() {
+	case StateRunning:
+		health.Healthy, health.Reason = s.runningHealth(health, maxPollAge)
+	case StatePaused:
+		health.Healthy = health.WorkersAlive > 0
+		if !health.Healthy {
+			health.Reason = "no workers alive"
+		}
+	default:
+		health.Reason = "not running"
+	}
+	return health
+}
+
+// runningHealth decides whether a running subscriber is healthy and why not
+func (s *SQSSubscriber) runningHealth(health SubscriberHealth, maxPollAge time.Duration) (bool, string) {
+	switch {
+	case health.PollersAlive == 0:
+		return false, "no pollers alive"
+	case health.WorkersAlive == 0:
+		return false, "no workers alive"
+	case health.Backpressured:
+		return true, ""
+	}
+
+	// Polling restarts on Start and Resume; older successful polls do not count
+	since := time.Unix(0, s.pollingSince.Load())
+	if health.LastPollSuccess != nil && health.LastPollSuccess.After(since) {
+		since = *health.LastPollSuccess
+	}
+	if time.Since(since) <= maxPollAge {
+		return true, ""
+	}
+	if health.LastPollSuccess == nil {
+		return false, "no successful poll since start"
+	}
+	return false, "last successful poll is stale"
+}
+
+// HealthHandler returns a /healthz handler reporting the health of the subscribers.
+// It responds 200 when every subscriber is healthy and 503 otherwise
+func HealthHandler(maxPollAge time.Duration, subscribers ...*SQSSubscriber) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		healthy := true
+		report := make([]SubscriberHealth, 0, len(subscribers))
+		for _, s := range subscribers {
+			health := s.Health(maxPollAge)
+			healthy = healthy && health.Healthy
+			report = append(report, health)
+		}
+
+		status, message := http.StatusOK, "healthy"
+		if !healthy {
+			status, message = http.StatusServiceUnavailable, "unhealthy"
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(ResponseBuilder(healthy, message, report, nil))
+	})
+}
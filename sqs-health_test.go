@@ -0,0 +1,126 @@
+package pkgcommon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// failingReceiveSQS is a MemorySQS whose receive calls fail or hang on demand
+type failingReceiveSQS struct {
+	*MemorySQS
+	fail atomic.Bool
+	hang atomic.Bool
+}
+
+func (f *failingReceiveSQS) ReceiveMessage(ctx context.Context, params *sqs.ReceiveMessageInput, optFns ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error) {
+	if f.hang.Load() {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	if f.fail.Load() {
+		return nil, errors.New("receive failed")
+	}
+	return f.MemorySQS.ReceiveMessage(ctx, params, optFns...)
+}
+
+func TestSubscriberHealth(t *testing.T) {
+	const maxPollAge = 300 * time.Millisecond
+
+	tests := []struct {
+		name    string
+		setup   func(t *testing.T, client *failingReceiveSQS, sub *SQSSubscriber)
+		healthy bool
+		reason  string
+	}{
+		{
+			name: "saturated workers are healthy",
+			setup: func(t *testing.T, client *failingReceiveSQS, sub *SQSSubscriber) {
+				for _, body := range []string{"a", "b", "c"} {
+					sendTestMessage(t, client.MemorySQS, "orders", body, "")
+				}
+				block := make(chan struct{})
+				t.Cleanup(func() { close(block) })
+				sub.AddContextHandler(func(ctx context.Context, msg *types.Message) error {
+					<-block
+					return nil
+				})
+			},
+			healthy: true,
+		},
+		{
+			name: "no successful poll since start",
+			setup: func(t *testing.T, client *failingReceiveSQS, sub *SQSSubscriber) {
+				client.hang.Store(true)
+			},
+			reason: "no successful poll since start",
+		},
+		{
+			name: "stale poll",
+			setup: func(t *testing.T, client *failingReceiveSQS, sub *SQSSubscriber) {
+				sendTestMessage(t, client.MemorySQS, "orders", "a", "")
+				go func() {
+					for sub.lastPoll.Load() == 0 {
+						time.Sleep(10 * time.Millisecond)
+					}
+					client.fail.Store(true)
+				}()
+			},
+			reason: "last successful poll is stale",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mem := NewMemorySQS()
+			mem.CreateQueue("orders", MemoryQueueOptions{})
+			client := &failingReceiveSQS{MemorySQS: mem}
+
+			sub := newTestSubscriber(t, mem, "orders", 1,
+				WithSQSClient(client),
+				WithMaxMessages(1),
+			)
+			tt.setup(t, client, sub)
+			sub.Start()
+			waitFor(t, time.Second, func() bool {
+				return sub.pollersAlive.Load() > 0 && sub.workersAlive.Load() > 0
+			})
+
+			if health := sub.Health(maxPollAge); !health.Healthy {
+				t.Fatalf("subscriber unhealthy right after start: %+v", health)
+			}
+			time.Sleep(3 * maxPollAge)
+
+			health := sub.Health(maxPollAge)
+			if health.Healthy != tt.healthy || health.Reason != tt.reason {
+				t.Errorf("Health() = healthy %v reason %q, want healthy %v reason %q", health.Healthy, health.Reason, tt.healthy, tt.reason)
+			}
+		})
+	}
+}
+
+func TestSubscriberHealthLastPollSuccess(t *testing.T) {
+	mem := NewMemorySQS()
+	mem.CreateQueue("orders", MemoryQueueOptions{})
+	sub := newTestSubscriber(t, mem, "orders", 1)
+
+	body, err := json.Marshal(sub.Health(time.Second))
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	if strings.Contains(string(body), "last_poll_success") {
+		t.Errorf("health before the first poll reports last_poll_success: %s", body)
+	}
+
+	sub.Start()
+	waitFor(t, 2*time.Second, func() bool {
+		return sub.Health(time.Second).LastPollSuccess != nil
+	})
+}
@@ -0,0 +1,82 @@
+package pkgcommon
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// maxVisibilityTimeout is the SQS limit for the visibility of a message since it was received
+const maxVisibilityTimeout = 12 * time.Hour
+
+// heartbeat extends the visibility of a message while it waits for a worker and its handlers run
+type heartbeat struct {
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// startHeartbeat begins extending the visibility of msg in the background from the moment
+// it is dispatched until it is processed or released.
+// It returns nil when the heartbeat is disabled or the message has no receipt handle
+func (s *SQSSubscriber) startHeartbeat(msg *types.Message, receivedAt time.Time) *heartbeat {
+	if !s.opts.heartbeatEnabled() || msg.ReceiptHandle == nil {
+		return nil
+	}
+
+	hb := &heartbeat{stop: make(chan struct{})}
+	hb.wg.Add(1)
+	go func() {
+		defer hb.wg.Done()
+
+		ticker := time.NewTicker(s.opts.heartbeatPeriod())
+		defer ticker.Stop()
+
+		deadline := receivedAt.Add(s.opts.maxExtension)
+		if limit := receivedAt.Add(maxVisibilityTimeout - s.opts.visibility()); deadline.After(limit) {
+			deadline = limit
+		}
+
+		for {
+			select {
+			case <-hb.stop:
+				return
+			case <-s.ctx.Done():
+				return
+			case now := <-ticker.C:
+				if now.After(deadline) {
+					log.Printf("Heartbeat stopped for message %s: maximum extension reached", stringValue(msg.MessageId))
+					return
+				}
+				_, err := s.client.ChangeMessageVisibility(s.ctx, &sqs.ChangeMessageVisibilityInput{
+					QueueUrl:          s.queueURL,
+					ReceiptHandle:     msg.ReceiptHandle,
+					VisibilityTimeout: s.opts.visibilityTimeout,
+				})
+				if err != nil {
+					log.Printf("Error extending message visibility: %v", err)
+				}
+			}
+		}
+	}()
+	return hb
+}
+
+// Stop ends the heartbeat and waits for any pending visibility change to complete
+func (hb *heartbeat) Stop() {
+	if hb == nil {
+		return
+	}
+	close(hb.stop)
+	hb.wg.Wait()
+}
+
+// stringValue returns the value of a string pointer or an empty string
+func stringValue(v *string) string {
+	if v == nil {
+		return ""
+	}
+	return *v
+}
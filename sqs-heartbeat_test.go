@@ -0,0 +1,33 @@
+package pkgcommon
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+func TestHeartbeatOutpacesShortVisibilityTimeout(t *testing.T) {
+	mem := NewMemorySQS()
+	mem.CreateQueue("orders", MemoryQueueOptions{})
+	sendTestMessage(t, mem, "orders", "slow", "")
+
+	calls := newHandlerCalls()
+	// The default heartbeat interval is longer than the visibility timeout
+	sub := newTestSubscriber(t, mem, "orders", 2, WithVisibilityTimeout(1))
+	sub.AddContextHandler(func(ctx context.Context, msg *types.Message) error {
+		calls.record(msg)
+		time.Sleep(2500 * time.Millisecond)
+		return nil
+	})
+	sub.Start()
+
+	waitFor(t, 10*time.Second, func() bool {
+		visible, notVisible := queueDepth(t, mem, "orders")
+		return visible+notVisible == 0
+	})
+	if got := calls.calls("slow"); len(got) != 1 {
+		t.Errorf("message processed with receive counts %v, want exactly once", got)
+	}
+}
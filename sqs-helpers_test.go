@@ -0,0 +1,95 @@
+package pkgcommon
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// newTestSubscriber creates a subscriber consuming queueName from mem
+func newTestSubscriber(t *testing.T, mem *MemorySQS, queueName string, workers int, opts ...SubscriberOption) *SQSSubscriber {
+	t.Helper()
+
+	defaults := []SubscriberOption{
+		WithSQSClient(mem),
+		WithAccountID("000000000000"),
+		WithQueueNamePrefix(""),
+		WithQueueURL(mem.QueueURL(queueName)),
+		WithWaitTimeSeconds(1),
+		WithDeleteBatching(10*time.Millisecond, 3),
+	}
+	sub, err := NewSQSSubscriber(queueName, workers, append(defaults, opts...)...)
+	if err != nil {
+		t.Fatalf("NewSQSSubscriber: %v", err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		sub.Shutdown(ctx)
+	})
+	return sub
+}
+
+// sendTestMessage sends body to queueName, in groupID when the queue is FIFO
+func sendTestMessage(t *testing.T, mem *MemorySQS, queueName, body, groupID string) {
+	t.Helper()
+
+	input := &sqs.SendMessageInput{
+		QueueUrl:    aws.String(mem.QueueURL(queueName)),
+		MessageBody: aws.String(body),
+	}
+	if groupID != "" {
+		input.MessageGroupId = aws.String(groupID)
+		input.MessageDeduplicationId = aws.String(body)
+	}
+	if _, err := mem.SendMessage(context.Background(), input); err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+}
+
+// queueDepth returns the visible and in-flight message counts of queueName
+func queueDepth(t *testing.T, mem *MemorySQS, queueName string) (visible, notVisible int) {
+	t.Helper()
+
+	out, err := mem.GetQueueAttributes(context.Background(), &sqs.GetQueueAttributesInput{
+		QueueUrl: aws.String(mem.QueueURL(queueName)),
+	})
+	if err != nil {
+		t.Fatalf("GetQueueAttributes: %v", err)
+	}
+	visible, _ = strconv.Atoi(out.Attributes[string(types.QueueAttributeNameApproximateNumberOfMessages)])
+	notVisible, _ = strconv.Atoi(out.Attributes[string(types.QueueAttributeNameApproximateNumberOfMessagesNotVisible)])
+	return visible, notVisible
+}
+
+// receiveAll receives every visible message of queueName without waiting
+func receiveAll(t *testing.T, mem *MemorySQS, queueName string) []types.Message {
+	t.Helper()
+
+	out, err := mem.ReceiveMessage(context.Background(), &sqs.ReceiveMessageInput{
+		QueueUrl:            aws.String(mem.QueueURL(queueName)),
+		MaxNumberOfMessages: 10,
+	})
+	if err != nil {
+		t.Fatalf("ReceiveMessage: %v", err)
+	}
+	return out.Messages
+}
+
+// waitFor polls cond until it holds or the timeout expires
+func waitFor(t *testing.T, timeout time.Duration, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met before timeout")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,176 @@
+package pkgcommon
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+const (
+	defaultIdempotencyTTL = 24 * time.Hour
+	// defaultIdempotencyLockTTL covers the longest a message can be processed with the default
+	// visibility timeout and heartbeat extension
+	defaultIdempotencyLockTTL = defaultVisibilityTimeout*time.Second + defaultMaxExtension
+	defaultIdempotencyPrefix  = "sqs:dedupe:"
+
+	idempotencyInProgress = "processing"
+	idempotencyDone       = "done"
+)
+
+// ErrDuplicateInProgress is the reason a message is left on the queue while
+// a duplicate of it is being processed by another worker
+var ErrDuplicateInProgress = errors.New("duplicate message is being processed")
+
+// Middleware wraps a ResultHandler with cross-cutting behavior
+type Middleware func(next ResultHandler) ResultHandler
+
+// IdempotencyOptions configures the idempotency middleware
+type IdempotencyOptions struct {
+	// TTL is how long a completed message is remembered. Defaults to 24 hours
+	TTL time.Duration
+	// LockTTL is how long the in-progress marker blocks duplicates. It should cover the
+	// maximum processing time of a message. Defaults to the time left until the deadline
+	// of the message context, which ends when the message becomes visible again, or to
+	// 15.5 minutes when the context has no deadline
+	LockTTL time.Duration
+	// KeyPrefix is prepended to every cache key. Defaults to "sqs:dedupe:"
+	KeyPrefix string
+	// KeyFunc derives the dedupe key of a message. Defaults to DedupeKeyByTypeID
+	KeyFunc func(msg *types.Message) string
+}
+
+// setNXCache is implemented by caches that can set a key only when it is absent
+type setNXCache interface {
+	SetNX(key string, value interface{}, exp time.Duration) (bool, error)
+}
+
+// setExCache is implemented by caches that can set a key without panicking on connection failures
+type setExCache interface {
+	SetEx(key string, value interface{}, exp time.Duration) error
+}
+
+// DedupeKeyByMessageID uses the SQS MessageId as the dedupe key
+func DedupeKeyByMessageID(msg *types.Message) string {
+	return stringValue(msg.MessageId)
+}
+
+// DedupeKeyByTypeID uses the "typeId" attribute set by SNSNotification as the dedupe key,
+// falling back to the SQS MessageId
+func DedupeKeyByTypeID(msg *types.Message) string {
+	if typeID, ok := MessageAttribute(msg, "typeId"); ok && typeID != "" {
+		return typeID
+	}
+	return DedupeKeyByMessageID(msg)
+}
+
+// Idempotent returns a middleware that acks duplicate deliveries without running the handler.
+// Completed messages are recorded in cache, or in MyCache when cache is nil, and an
+// in-progress marker leaves concurrent duplicates on the queue until the first one completes
+func Idempotent(cache AppCache, options IdempotencyOptions) Middleware {
+	if options.TTL <= 0 {
+		options.TTL = defaultIdempotencyTTL
+	}
+	if options.KeyPrefix == "" {
+		options.KeyPrefix = defaultIdempotencyPrefix
+	}
+	if options.KeyFunc == nil {
+		options.KeyFunc = DedupeKeyByTypeID
+	}
+
+	return func(next ResultHandler) ResultHandler {
+		return func(ctx context.Context, msg *types.Message) Result {
+			c := cache
+			if c == nil {
+				c = MyCache
+			}
+			key := options.KeyFunc(msg)
+			if c == nil || key == "" {
+				return next(ctx, msg)
+			}
+			key = options.KeyPrefix + key
+
+			if status, err := c.Get(key); err == nil && status == idempotencyDone {
+				log.Printf("Skipping duplicate message %s", stringValue(msg.MessageId))
+				return Ack()
+			}
+
+			claimed, err := claimKey(c, key, lockTTL(ctx, options.LockTTL))
+			if err != nil {
+				log.Printf("Error claiming dedupe key %s: %v", key, err)
+				return next(ctx, msg)
+			}
+			if !claimed {
+				return Leave(ErrDuplicateInProgress)
+			}
+
+			completed := false
+			defer func() {
+				if completed {
+					return
+				}
+				// Release the marker so a redelivery can process the message,
+				// also when next panics
+				err := callRecovered("dedupe cache", msg, func() error {
+					_, err := c.Delete(key)
+					return err
+				})
+				if err != nil {
+					log.Printf("Error releasing dedupe key %s: %v", key, err)
+				}
+			}()
+
+			res := next(ctx, msg)
+			if res.Action == ActionAck {
+				completed = true
+				// The message is processed; a cache failure must not turn the ack into a redelivery
+				err := callRecovered("dedupe cache", msg, func() error {
+					return completeKey(c, key, options.TTL)
+				})
+				if err != nil {
+					log.Printf("Error recording dedupe key %s: %v", key, err)
+				}
+			}
+			return res
+		}
+	}
+}
+
+// lockTTL returns the configured lock TTL, or the time left until the deadline of ctx
+func lockTTL(ctx context.Context, configured time.Duration) time.Duration {
+	if configured > 0 {
+		return configured
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		if left := time.Until(deadline); left > 0 {
+			return left
+		}
+	}
+	return defaultIdempotencyLockTTL
+}
+
+// completeKey records key as done, through SetEx on caches that provide it
+func completeKey(cache AppCache, key string, ttl time.Duration) error {
+	if c, ok := cache.(setExCache); ok {
+		return c.SetEx(key, idempotencyDone, ttl)
+	}
+	return cache.Set(key, idempotencyDone, ttl)
+}
+
+// claimKey sets the in-progress marker for key when no marker or completion is recorded.
+// Caches without SetNX fall back to a check followed by a set, which is not atomic
+func claimKey(cache AppCache, key string, lockTTL time.Duration) (bool, error) {
+	if c, ok := cache.(setNXCache); ok {
+		return c.SetNX(key, idempotencyInProgress, lockTTL)
+	}
+
+	if _, err := cache.Get(key); err == nil {
+		return false, nil
+	}
+	if err := cache.Set(key, idempotencyInProgress, lockTTL); err != nil {
+		return false, err
+	}
+	return true, nil
+}
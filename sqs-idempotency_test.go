@@ -0,0 +1,130 @@
+package pkgcommon
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/patrickmn/go-cache"
+)
+
+func TestIdempotentReleasesMarker(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler ResultHandler
+	}{
+		{"nack", func(ctx context.Context, msg *types.Message) Result { return Nack(nil) }},
+		{"panic", func(ctx context.Context, msg *types.Message) Result { panic("handler failed") }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &InMemoryCache{client: cache.New(time.Minute, time.Minute)}
+			dedupe := Idempotent(store, IdempotencyOptions{KeyFunc: DedupeKeyByMessageID})
+			msg := &types.Message{MessageId: aws.String("m-1")}
+
+			if res := Recover(nil)(dedupe(tt.handler))(context.Background(), msg); res.Action == ActionAck {
+				t.Fatalf("failed handler was acked")
+			}
+
+			calls := 0
+			res := dedupe(func(ctx context.Context, msg *types.Message) Result {
+				calls++
+				return Ack()
+			})(context.Background(), msg)
+			if res.Action != ActionAck || calls != 1 {
+				t.Errorf("redelivery got %s with %d handler calls, want ack after one call", res.Action, calls)
+			}
+		})
+	}
+}
+
+func TestIdempotencyLockTTL(t *testing.T) {
+	withDeadline, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+
+	tests := []struct {
+		name       string
+		ctx        context.Context
+		configured time.Duration
+		min, max   time.Duration
+	}{
+		{"configured", withDeadline, time.Minute, time.Minute, time.Minute},
+		{"message deadline", withDeadline, 0, 59 * time.Minute, time.Hour},
+		{"no deadline", context.Background(), 0, defaultIdempotencyLockTTL, defaultIdempotencyLockTTL},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := lockTTL(tt.ctx, tt.configured); got < tt.min || got > tt.max {
+				t.Errorf("lockTTL() = %v, want between %v and %v", got, tt.min, tt.max)
+			}
+		})
+	}
+	if defaultIdempotencyLockTTL < defaultMaxExtension {
+		t.Errorf("default lock TTL %v is shorter than the default heartbeat extension %v", defaultIdempotencyLockTTL, defaultMaxExtension)
+	}
+}
+
+// failingCache is an AppCache without SetEx whose completion writes fail,
+// by panicking or returning an error
+type failingCache struct {
+	store  *InMemoryCache
+	panics bool
+}
+
+func newFailingCache(panics bool) *failingCache {
+	return &failingCache{store: &InMemoryCache{client: cache.New(time.Minute, time.Minute)}, panics: panics}
+}
+
+func (c *failingCache) Set(key string, value interface{}, exp time.Duration) error {
+	if value == idempotencyInProgress {
+		return c.store.Set(key, value, exp)
+	}
+	if c.panics {
+		panic("redis: connection refused")
+	}
+	return errors.New("redis: connection refused")
+}
+
+func (c *failingCache) Get(key string) (string, error)   { return c.store.Get(key) }
+func (c *failingCache) Exists(key string) int64          { return c.store.Exists(key) }
+func (c *failingCache) Delete(key string) (int64, error) { return c.store.Delete(key) }
+
+// setExFailingCache also provides SetEx, which reports the failure as an error
+type setExFailingCache struct {
+	*failingCache
+}
+
+func (c setExFailingCache) SetEx(key string, value interface{}, exp time.Duration) error {
+	return errors.New("redis: connection refused")
+}
+
+func TestIdempotentAcksWhenRecordingFails(t *testing.T) {
+	tests := []struct {
+		name  string
+		cache AppCache
+	}{
+		{"set returns an error", newFailingCache(false)},
+		{"set panics", newFailingCache(true)},
+		{"set ex is used instead of a panicking set", setExFailingCache{newFailingCache(true)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dedupe := Idempotent(tt.cache, IdempotencyOptions{KeyFunc: DedupeKeyByMessageID})
+			msg := &types.Message{MessageId: aws.String("m-1")}
+
+			// No Recover middleware: a panic would fail the test
+			res := dedupe(func(ctx context.Context, msg *types.Message) Result {
+				return Ack()
+			})(context.Background(), msg)
+			if res.Action != ActionAck {
+				t.Errorf("processed message got %s, want ack", res.Action)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package pkgcommon
+
+import (
+	"log"
+	"time"
+)
+
+// SubscriberState is the lifecycle state of an SQSSubscriber
+type SubscriberState int32
+
+const (
+	// StateStopped is the state of a subscriber that is not running. It can be started
+	StateStopped SubscriberState = iota
+	// StateRunning is the state of a subscriber that is polling and processing messages
+	StateRunning
+	// StatePaused is the state of a running subscriber that does not poll for new messages
+	StatePaused
+	// StateStopping is the state of a subscriber that is draining in-flight messages
+	StateStopping
+)
+
+func (st SubscriberState) String() string {
+	switch st {
+	case StateStopped:
+		return "stopped"
+	case StateRunning:
+		return "running"
+	case StatePaused:
+		return "paused"
+	case StateStopping:
+		return "stopping"
+	default:
+		return "unknown"
+	}
+}
+
+// State returns the current lifecycle state of the subscriber
+func (s *SQSSubscriber) State() SubscriberState {
+	return SubscriberState(s.state.Load())
+}
+
+// setState records the lifecycle state of the subscriber
+func (s *SQSSubscriber) setState(state SubscriberState) {
+	s.state.Store(int32(state))
+}
+
+// Pause stops polling for new messages without stopping the subscriber.
+// Receive calls already in progress complete and in-flight messages are processed
+func (s *SQSSubscriber) Pause() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.State() != StateRunning {
+		return
+	}
+
+	s.pauseMu.Lock()
+	s.resumed = make(chan struct{})
+	s.pauseMu.Unlock()
+
+	s.setState(StatePaused)
+	log.Printf("Subscriber %s paused", stringValue(s.queueURL))
+}
+
+// Resume restarts polling of a paused subscriber
+func (s *SQSSubscriber) Resume() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.State() != StatePaused {
+		return
+	}
+
+	s.pollingSince.Store(time.Now().UnixNano())
+	s.openPauseGate()
+	s.setState(StateRunning)
+	log.Printf("Subscriber %s resumed", stringValue(s.queueURL))
+}
+
+// openPauseGate releases pollers waiting while the subscriber is paused
+func (s *SQSSubscriber) openPauseGate() {
+	s.pauseMu.Lock()
+	defer s.pauseMu.Unlock()
+
+	if s.resumed != nil {
+		close(s.resumed)
+		s.resumed = nil
+	}
+}
+
+// waitWhilePaused blocks a poller while the subscriber is paused.
+// Returns false when the subscriber stops polling
+func (s *SQSSubscriber) waitWhilePaused() bool {
+	s.pauseMu.Lock()
+	resumed := s.resumed
+	s.pauseMu.Unlock()
+
+	if resumed == nil {
+		return true
+	}
+
+	select {
+	case <-resumed:
+		return true
+	case <-s.pollCtx.Done():
+		return false
+	}
+}
@@ -0,0 +1,126 @@
+package pkgcommon
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+func TestSubscriberStateTransitions(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps []string
+		want  []SubscriberState
+	}{
+		{
+			name:  "pause and resume",
+			steps: []string{"start", "pause", "resume"},
+			want:  []SubscriberState{StateRunning, StatePaused, StateRunning},
+		},
+		{
+			name:  "pause and resume are ignored when not applicable",
+			steps: []string{"pause", "resume", "start", "resume", "pause", "pause", "start"},
+			want:  []SubscriberState{StateStopped, StateStopped, StateRunning, StateRunning, StatePaused, StatePaused, StatePaused},
+		},
+		{
+			name:  "paused subscriber shuts down",
+			steps: []string{"start", "pause", "shutdown"},
+			want:  []SubscriberState{StateRunning, StatePaused, StateStopped},
+		},
+		{
+			name:  "stopped subscriber restarts",
+			steps: []string{"start", "shutdown", "start", "shutdown"},
+			want:  []SubscriberState{StateRunning, StateStopped, StateRunning, StateStopped},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mem := NewMemorySQS()
+			mem.CreateQueue("orders", MemoryQueueOptions{})
+			sub := newTestSubscriber(t, mem, "orders", 1)
+			sub.AddContextHandler(func(ctx context.Context, msg *types.Message) error {
+				return nil
+			})
+
+			for i, step := range tt.steps {
+				switch step {
+				case "start":
+					sub.Start()
+				case "pause":
+					sub.Pause()
+				case "resume":
+					sub.Resume()
+				case "shutdown":
+					if err := sub.Shutdown(context.Background()); err != nil {
+						t.Fatalf("Shutdown: %v", err)
+					}
+				}
+				if got := sub.State(); got != tt.want[i] {
+					t.Fatalf("state after %s (step %d) = %s, want %s", step, i, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestPausedSubscriberDoesNotPoll(t *testing.T) {
+	mem := NewMemorySQS()
+	mem.CreateQueue("orders", MemoryQueueOptions{})
+
+	calls := newHandlerCalls()
+	sub := newTestSubscriber(t, mem, "orders", 2)
+	sub.AddContextHandler(func(ctx context.Context, msg *types.Message) error {
+		calls.record(msg)
+		return nil
+	})
+	sub.Start()
+
+	sendTestMessage(t, mem, "orders", "before", "")
+	waitFor(t, 3*time.Second, func() bool { return len(calls.calls("before")) > 0 })
+
+	sub.Pause()
+	// Let the receive calls in progress complete
+	time.Sleep(1200 * time.Millisecond)
+	sendTestMessage(t, mem, "orders", "paused", "")
+	time.Sleep(300 * time.Millisecond)
+	if got := calls.calls("paused"); len(got) != 0 {
+		t.Fatalf("message processed while paused")
+	}
+	if visible, _ := queueDepth(t, mem, "orders"); visible != 1 {
+		t.Errorf("%d messages visible while paused, want 1", visible)
+	}
+
+	sub.Resume()
+	waitFor(t, 3*time.Second, func() bool { return len(calls.calls("paused")) > 0 })
+}
+
+func TestRestartedSubscriberProcessesMessages(t *testing.T) {
+	mem := NewMemorySQS()
+	mem.CreateQueue("orders", MemoryQueueOptions{})
+
+	calls := newHandlerCalls()
+	sub := newTestSubscriber(t, mem, "orders", 2)
+	sub.AddContextHandler(func(ctx context.Context, msg *types.Message) error {
+		calls.record(msg)
+		return nil
+	})
+
+	for _, body := range []string{"first", "second"} {
+		sub.Start()
+		sendTestMessage(t, mem, "orders", body, "")
+		waitFor(t, 3*time.Second, func() bool { return len(calls.calls(body)) > 0 })
+		if err := sub.Shutdown(context.Background()); err != nil {
+			t.Fatalf("Shutdown: %v", err)
+		}
+		if visible, notVisible := queueDepth(t, mem, "orders"); visible+notVisible != 0 {
+			t.Errorf("message %s left on the queue after shutdown", body)
+		}
+	}
+	if got := calls.processed(); !slices.Equal(got, []string{"first", "second"}) {
+		t.Errorf("processed %v, want [first second]", got)
+	}
+}
@@ -0,0 +1,458 @@
+package pkgcommon
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+const (
+	memorySQSBaseURL          = "https://sqs.memory.local/000000000000/"
+	defaultMemoryVisibility   = 30 * time.Second
+	memoryDeduplicationWindow = 5 * time.Minute
+	// memoryPollInterval bounds how long a long poll sleeps before re-checking visibility
+	memoryPollInterval = 50 * time.Millisecond
+)
+
+// MemoryQueueOptions configures a queue of MemorySQS
+type MemoryQueueOptions struct {
+	// VisibilityTimeout is used when a receive call does not set one. Defaults to 30 seconds
+	VisibilityTimeout time.Duration
+	// DeadLetterQueue is the name of the queue messages are moved to once they
+	// were received MaxReceiveCount times, like an SQS redrive policy
+	DeadLetterQueue string
+	MaxReceiveCount int
+	// ContentBasedDeduplication derives the deduplication ID of FIFO messages from the body
+	ContentBasedDeduplication bool
+}
+
+// MemorySQS is an in-memory QueueClient for running consumers offline. It models
+// visibility timeouts, receive counts, FIFO message groups and redrive to a dead-letter queue.
+// Queue names ending in .fifo are FIFO queues
+type MemorySQS struct {
+	queues  map[string]*memoryQueue
+	changed chan struct{}
+	nextID  uint64
+	mu      sync.Mutex
+}
+
+// memoryQueue is a queue of MemorySQS; messages are kept in send order
+type memoryQueue struct {
+	name     string
+	url      string
+	fifo     bool
+	opts     MemoryQueueOptions
+	messages []*memoryMessage
+	dedup    map[string]memoryDedup
+	sequence int64
+}
+
+// memoryDedup records a FIFO deduplication ID
+type memoryDedup struct {
+	messageID string
+	sentAt    time.Time
+}
+
+// memoryMessage is a message stored in a memoryQueue
+type memoryMessage struct {
+	id              string
+	body            string
+	attributes      map[string]types.MessageAttributeValue
+	groupID         string
+	dedupID         string
+	sequence        int64
+	sentAt          time.Time
+	firstReceivedAt time.Time
+	receiveCount    int
+	visibleAt       time.Time
+	receiptHandle   string
+}
+
+var _ QueueClient = (*MemorySQS)(nil)
+
+// NewMemorySQS creates an empty MemorySQS
+func NewMemorySQS() *MemorySQS {
+	return &MemorySQS{
+		queues:  make(map[string]*memoryQueue),
+		changed: make(chan struct{}),
+	}
+}
+
+// CreateQueue creates a queue and returns its URL. Creating an existing queue returns its URL
+func (m *MemorySQS) CreateQueue(name string, opts MemoryQueueOptions) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	url := memorySQSBaseURL + name
+	if _, ok := m.queues[url]; ok {
+		return url
+	}
+	if opts.VisibilityTimeout <= 0 {
+		opts.VisibilityTimeout = defaultMemoryVisibility
+	}
+
+	m.queues[url] = &memoryQueue{
+		name:  name,
+		url:   url,
+		fifo:  strings.HasSuffix(name, ".fifo"),
+		opts:  opts,
+		dedup: make(map[string]memoryDedup),
+	}
+	return url
+}
+
+// QueueURL returns the URL of a queue created with CreateQueue
+func (m *MemorySQS) QueueURL(name string) string {
+	return memorySQSBaseURL + name
+}
+
+// queue returns the queue at url. The caller must hold mu
+func (m *MemorySQS) queue(url *string) (*memoryQueue, error) {
+	q, ok := m.queues[stringValue(url)]
+	if !ok {
+		return nil, &types.QueueDoesNotExist{Message: aws.String(fmt.Sprintf("queue %s does not exist", stringValue(url)))}
+	}
+	return q, nil
+}
+
+// newID returns a unique identifier. The caller must hold mu
+func (m *MemorySQS) newID(prefix string) string {
+	m.nextID++
+	return fmt.Sprintf("%s-%d", prefix, m.nextID)
+}
+
+// notify wakes long polls waiting for messages. The caller must hold mu
+func (m *MemorySQS) notify() {
+	close(m.changed)
+	m.changed = make(chan struct{})
+}
+
+// inFlight reports whether the message was received and is still invisible
+func (msg *memoryMessage) inFlight(now time.Time) bool {
+	return msg.receiptHandle != "" && now.Before(msg.visibleAt)
+}
+
+// toSQS converts the message to the SQS representation returned by ReceiveMessage
+func (msg *memoryMessage) toSQS() types.Message {
+	sum := md5.Sum([]byte(msg.body))
+	attributes := map[string]string{
+		string(types.MessageSystemAttributeNameApproximateReceiveCount):          strconv.Itoa(msg.receiveCount),
+		string(types.MessageSystemAttributeNameSentTimestamp):                    strconv.FormatInt(msg.sentAt.UnixMilli(), 10),
+		string(types.MessageSystemAttributeNameApproximateFirstReceiveTimestamp): strconv.FormatInt(msg.firstReceivedAt.UnixMilli(), 10),
+	}
+	if msg.groupID != "" {
+		attributes[string(types.MessageSystemAttributeNameMessageGroupId)] = msg.groupID
+		attributes[string(types.MessageSystemAttributeNameMessageDeduplicationId)] = msg.dedupID
+		attributes[string(types.MessageSystemAttributeNameSequenceNumber)] = strconv.FormatInt(msg.sequence, 10)
+	}
+
+	messageAttributes := make(map[string]types.MessageAttributeValue, len(msg.attributes))
+	for name, value := range msg.attributes {
+		messageAttributes[name] = value
+	}
+
+	return types.Message{
+		MessageId:         aws.String(msg.id),
+		ReceiptHandle:     aws.String(msg.receiptHandle),
+		Body:              aws.String(msg.body),
+		MD5OfBody:         aws.String(hex.EncodeToString(sum[:])),
+		Attributes:        attributes,
+		MessageAttributes: messageAttributes,
+	}
+}
+
+// findByReceiptHandle returns the index of the message with the given receipt handle
+func (q *memoryQueue) findByReceiptHandle(handle *string) int {
+	for i, msg := range q.messages {
+		if handle != nil && msg.receiptHandle == *handle {
+			return i
+		}
+	}
+	return -1
+}
+
+// SendMessage adds a message to the queue
+func (m *MemorySQS) SendMessage(_ context.Context, params *sqs.SendMessageInput, _ ...func(*sqs.Options)) (*sqs.SendMessageOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	q, err := m.queue(params.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+	if params.MessageBody == nil || *params.MessageBody == "" {
+		return nil, fmt.Errorf("message body is required")
+	}
+
+	now := time.Now()
+	msg := &memoryMessage{
+		body:       *params.MessageBody,
+		attributes: make(map[string]types.MessageAttributeValue, len(params.MessageAttributes)),
+		sentAt:     now,
+		visibleAt:  now.Add(time.Duration(params.DelaySeconds) * time.Second),
+	}
+	for name, value := range params.MessageAttributes {
+		msg.attributes[name] = value
+	}
+
+	if q.fifo {
+		if params.MessageGroupId == nil || *params.MessageGroupId == "" {
+			return nil, fmt.Errorf("MessageGroupId is required for FIFO queue %s", q.name)
+		}
+		msg.groupID = *params.MessageGroupId
+		msg.dedupID = stringValue(params.MessageDeduplicationId)
+		if msg.dedupID == "" && q.opts.ContentBasedDeduplication {
+			sum := sha256.Sum256([]byte(msg.body))
+			msg.dedupID = hex.EncodeToString(sum[:])
+		}
+		if msg.dedupID == "" {
+			return nil, fmt.Errorf("MessageDeduplicationId is required for FIFO queue %s", q.name)
+		}
+		if seen, ok := q.dedup[msg.dedupID]; ok && now.Sub(seen.sentAt) < memoryDeduplicationWindow {
+			return &sqs.SendMessageOutput{MessageId: aws.String(seen.messageID)}, nil
+		}
+		q.sequence++
+		msg.sequence = q.sequence
+	} else if params.MessageGroupId != nil || params.MessageDeduplicationId != nil {
+		return nil, fmt.Errorf("MessageGroupId and MessageDeduplicationId are not valid for standard queue %s", q.name)
+	}
+
+	msg.id = m.newID("msg")
+	if q.fifo {
+		q.dedup[msg.dedupID] = memoryDedup{messageID: msg.id, sentAt: now}
+	}
+	q.messages = append(q.messages, msg)
+	m.notify()
+
+	sum := md5.Sum([]byte(msg.body))
+	output := &sqs.SendMessageOutput{
+		MessageId:        aws.String(msg.id),
+		MD5OfMessageBody: aws.String(hex.EncodeToString(sum[:])),
+	}
+	if q.fifo {
+		output.SequenceNumber = aws.String(strconv.FormatInt(msg.sequence, 10))
+	}
+	return output, nil
+}
+
+// ReceiveMessage returns visible messages, waiting up to WaitTimeSeconds for one to arrive.
+// On FIFO queues a message group is skipped while one of its messages is in flight
+func (m *MemorySQS) ReceiveMessage(ctx context.Context, params *sqs.ReceiveMessageInput, _ ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error) {
+	maxMessages := int(params.MaxNumberOfMessages)
+	if maxMessages <= 0 {
+		maxMessages = 1
+	}
+	maxMessages = min(maxMessages, 10)
+	deadline := time.Now().Add(time.Duration(params.WaitTimeSeconds) * time.Second)
+
+	for {
+		m.mu.Lock()
+		q, err := m.queue(params.QueueUrl)
+		if err != nil {
+			m.mu.Unlock()
+			return nil, err
+		}
+		visibility := q.opts.VisibilityTimeout
+		if params.VisibilityTimeout > 0 {
+			visibility = time.Duration(params.VisibilityTimeout) * time.Second
+		}
+		messages := m.receive(q, maxMessages, visibility)
+		changed := m.changed
+		m.mu.Unlock()
+
+		remaining := time.Until(deadline)
+		if len(messages) > 0 || remaining <= 0 {
+			return &sqs.ReceiveMessageOutput{Messages: messages}, nil
+		}
+
+		timer := time.NewTimer(min(remaining, memoryPollInterval))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-changed:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// receive takes up to maxMessages visible messages from q, moving messages that exceeded
+// the receive limit to the dead-letter queue. The caller must hold mu
+func (m *MemorySQS) receive(q *memoryQueue, maxMessages int, visibility time.Duration) []types.Message {
+	now := time.Now()
+	var dlq *memoryQueue
+	if q.opts.DeadLetterQueue != "" && q.opts.MaxReceiveCount > 0 {
+		dlq = m.queues[memorySQSBaseURL+q.opts.DeadLetterQueue]
+	}
+
+	var received []types.Message
+	blocked := make(map[string]bool)
+	kept := q.messages[:0]
+	for _, msg := range q.messages {
+		switch {
+		case len(received) >= maxMessages:
+		case q.fifo && blocked[msg.groupID]:
+		case q.fifo && msg.inFlight(now):
+			// Later messages of the group wait for this one
+			blocked[msg.groupID] = true
+		case msg.visibleAt.After(now):
+		case dlq != nil && msg.receiveCount >= q.opts.MaxReceiveCount:
+			m.redrive(msg, dlq, now)
+			continue
+		default:
+			msg.receiveCount++
+			if msg.firstReceivedAt.IsZero() {
+				msg.firstReceivedAt = now
+			}
+			msg.receiptHandle = m.newID("rh")
+			msg.visibleAt = now.Add(visibility)
+			received = append(received, msg.toSQS())
+		}
+		kept = append(kept, msg)
+	}
+	clear(q.messages[len(kept):])
+	q.messages = kept
+	return received
+}
+
+// redrive moves msg to the dead-letter queue with a fresh receive state. The caller must hold mu
+func (m *MemorySQS) redrive(msg *memoryMessage, dlq *memoryQueue, now time.Time) {
+	msg.receiveCount = 0
+	msg.firstReceivedAt = time.Time{}
+	msg.receiptHandle = ""
+	msg.visibleAt = now
+	if dlq.fifo {
+		dlq.sequence++
+		msg.sequence = dlq.sequence
+	}
+	dlq.messages = append(dlq.messages, msg)
+}
+
+// DeleteMessage removes the message with the given receipt handle
+func (m *MemorySQS) DeleteMessage(_ context.Context, params *sqs.DeleteMessageInput, _ ...func(*sqs.Options)) (*sqs.DeleteMessageOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	q, err := m.queue(params.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.delete(q, params.ReceiptHandle); err != nil {
+		return nil, err
+	}
+	return &sqs.DeleteMessageOutput{}, nil
+}
+
+// DeleteMessageBatch removes the messages of every entry, reporting failures per entry
+func (m *MemorySQS) DeleteMessageBatch(_ context.Context, params *sqs.DeleteMessageBatchInput, _ ...func(*sqs.Options)) (*sqs.DeleteMessageBatchOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	q, err := m.queue(params.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	output := &sqs.DeleteMessageBatchOutput{}
+	for _, entry := range params.Entries {
+		if err := m.delete(q, entry.ReceiptHandle); err != nil {
+			output.Failed = append(output.Failed, types.BatchResultErrorEntry{
+				Id:          entry.Id,
+				Code:        aws.String("ReceiptHandleIsInvalid"),
+				Message:     aws.String(err.Error()),
+				SenderFault: true,
+			})
+			continue
+		}
+		output.Successful = append(output.Successful, types.DeleteMessageBatchResultEntry{Id: entry.Id})
+	}
+	return output, nil
+}
+
+// delete removes the message with the given receipt handle from q. The caller must hold mu
+func (m *MemorySQS) delete(q *memoryQueue, handle *string) error {
+	i := q.findByReceiptHandle(handle)
+	if i < 0 {
+		return &types.ReceiptHandleIsInvalid{Message: aws.String(fmt.Sprintf("receipt handle %s is invalid", stringValue(handle)))}
+	}
+	q.messages = append(q.messages[:i], q.messages[i+1:]...)
+	m.notify()
+	return nil
+}
+
+// ChangeMessageVisibility makes an in-flight message visible after the given timeout
+func (m *MemorySQS) ChangeMessageVisibility(_ context.Context, params *sqs.ChangeMessageVisibilityInput, _ ...func(*sqs.Options)) (*sqs.ChangeMessageVisibilityOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	q, err := m.queue(params.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+	i := q.findByReceiptHandle(params.ReceiptHandle)
+	if i < 0 {
+		return nil, &types.ReceiptHandleIsInvalid{Message: aws.String(fmt.Sprintf("receipt handle %s is invalid", stringValue(params.ReceiptHandle)))}
+	}
+
+	now := time.Now()
+	msg := q.messages[i]
+	if !msg.inFlight(now) {
+		return nil, &types.MessageNotInflight{Message: aws.String(fmt.Sprintf("message %s is not in flight", msg.id))}
+	}
+	msg.visibleAt = now.Add(time.Duration(params.VisibilityTimeout) * time.Second)
+	m.notify()
+	return &sqs.ChangeMessageVisibilityOutput{}, nil
+}
+
+// GetQueueUrl returns the URL of a queue created with CreateQueue
+func (m *MemorySQS) GetQueueUrl(_ context.Context, params *sqs.GetQueueUrlInput, _ ...func(*sqs.Options)) (*sqs.GetQueueUrlOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	url := memorySQSBaseURL + stringValue(params.QueueName)
+	if _, err := m.queue(&url); err != nil {
+		return nil, err
+	}
+	return &sqs.GetQueueUrlOutput{QueueUrl: aws.String(url)}, nil
+}
+
+// GetQueueAttributes reports the approximate number of visible and in-flight messages
+func (m *MemorySQS) GetQueueAttributes(_ context.Context, params *sqs.GetQueueAttributesInput, _ ...func(*sqs.Options)) (*sqs.GetQueueAttributesOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	q, err := m.queue(params.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	visible, notVisible := 0, 0
+	for _, msg := range q.messages {
+		if msg.visibleAt.After(now) {
+			notVisible++
+		} else {
+			visible++
+		}
+	}
+
+	return &sqs.GetQueueAttributesOutput{
+		Attributes: map[string]string{
+			string(types.QueueAttributeNameApproximateNumberOfMessages):           strconv.Itoa(visible),
+			string(types.QueueAttributeNameApproximateNumberOfMessagesNotVisible): strconv.Itoa(notVisible),
+			string(types.QueueAttributeNameVisibilityTimeout):                     strconv.Itoa(int(q.opts.VisibilityTimeout.Seconds())),
+			string(types.QueueAttributeNameFifoQueue):                             strconv.FormatBool(q.fifo),
+		},
+	}, nil
+}
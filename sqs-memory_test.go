@@ -0,0 +1,173 @@
+package pkgcommon
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// receiveBodies receives every visible message of queueName and returns their bodies
+func receiveBodies(t *testing.T, mem *MemorySQS, queueName string) []string {
+	t.Helper()
+
+	var bodies []string
+	for _, msg := range receiveAll(t, mem, queueName) {
+		bodies = append(bodies, stringValue(msg.Body))
+	}
+	return bodies
+}
+
+func TestMemorySQS(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, mem *MemorySQS)
+	}{
+		{
+			name: "received messages are invisible until the visibility timeout",
+			run: func(t *testing.T, mem *MemorySQS) {
+				mem.CreateQueue("orders", MemoryQueueOptions{VisibilityTimeout: 100 * time.Millisecond})
+				sendTestMessage(t, mem, "orders", "a", "")
+
+				if got := receiveBodies(t, mem, "orders"); !slices.Equal(got, []string{"a"}) {
+					t.Fatalf("received %v, want [a]", got)
+				}
+				if got := receiveBodies(t, mem, "orders"); len(got) != 0 {
+					t.Fatalf("received %v while in flight, want none", got)
+				}
+				time.Sleep(150 * time.Millisecond)
+				messages := receiveAll(t, mem, "orders")
+				if len(messages) != 1 || receiveCount(&messages[0]) != 2 {
+					t.Fatalf("received %d messages after the visibility timeout, want a with receive count 2", len(messages))
+				}
+			},
+		},
+		{
+			name: "deleted messages are not redelivered",
+			run: func(t *testing.T, mem *MemorySQS) {
+				mem.CreateQueue("orders", MemoryQueueOptions{VisibilityTimeout: 50 * time.Millisecond})
+				sendTestMessage(t, mem, "orders", "a", "")
+
+				msg := receiveAll(t, mem, "orders")[0]
+				_, err := mem.DeleteMessage(context.Background(), &sqs.DeleteMessageInput{
+					QueueUrl:      aws.String(mem.QueueURL("orders")),
+					ReceiptHandle: msg.ReceiptHandle,
+				})
+				if err != nil {
+					t.Fatalf("DeleteMessage: %v", err)
+				}
+				time.Sleep(100 * time.Millisecond)
+				if visible, notVisible := queueDepth(t, mem, "orders"); visible+notVisible != 0 {
+					t.Errorf("queue has %d messages after delete, want none", visible+notVisible)
+				}
+			},
+		},
+		{
+			name: "visibility change releases a message",
+			run: func(t *testing.T, mem *MemorySQS) {
+				mem.CreateQueue("orders", MemoryQueueOptions{})
+				sendTestMessage(t, mem, "orders", "a", "")
+
+				msg := receiveAll(t, mem, "orders")[0]
+				_, err := mem.ChangeMessageVisibility(context.Background(), &sqs.ChangeMessageVisibilityInput{
+					QueueUrl:      aws.String(mem.QueueURL("orders")),
+					ReceiptHandle: msg.ReceiptHandle,
+				})
+				if err != nil {
+					t.Fatalf("ChangeMessageVisibility: %v", err)
+				}
+				if got := receiveBodies(t, mem, "orders"); !slices.Equal(got, []string{"a"}) {
+					t.Errorf("received %v after release, want [a]", got)
+				}
+			},
+		},
+		{
+			name: "messages exceeding the receive count are redriven",
+			run: func(t *testing.T, mem *MemorySQS) {
+				mem.CreateQueue("dlq", MemoryQueueOptions{})
+				mem.CreateQueue("orders", MemoryQueueOptions{VisibilityTimeout: 10 * time.Millisecond, DeadLetterQueue: "dlq", MaxReceiveCount: 2})
+				sendTestMessage(t, mem, "orders", "a", "")
+
+				for i := 0; i < 2; i++ {
+					if got := receiveBodies(t, mem, "orders"); len(got) != 1 {
+						t.Fatalf("receive %d returned %v, want [a]", i+1, got)
+					}
+					time.Sleep(20 * time.Millisecond)
+				}
+				if got := receiveBodies(t, mem, "orders"); len(got) != 0 {
+					t.Errorf("received %v after the receive limit, want none", got)
+				}
+				if got := receiveBodies(t, mem, "dlq"); !slices.Equal(got, []string{"a"}) {
+					t.Errorf("dead-letter queue has %v, want [a]", got)
+				}
+			},
+		},
+		{
+			name: "fifo group is blocked while a message is in flight",
+			run: func(t *testing.T, mem *MemorySQS) {
+				mem.CreateQueue("orders.fifo", MemoryQueueOptions{})
+				sendTestMessage(t, mem, "orders.fifo", "a1", "a")
+				sendTestMessage(t, mem, "orders.fifo", "b1", "b")
+				sendTestMessage(t, mem, "orders.fifo", "a2", "a")
+
+				out, err := mem.ReceiveMessage(context.Background(), &sqs.ReceiveMessageInput{
+					QueueUrl:            aws.String(mem.QueueURL("orders.fifo")),
+					MaxNumberOfMessages: 1,
+				})
+				if err != nil || len(out.Messages) != 1 {
+					t.Fatalf("ReceiveMessage returned %v, %v", out, err)
+				}
+				if got := receiveBodies(t, mem, "orders.fifo"); !slices.Equal(got, []string{"b1"}) {
+					t.Errorf("received %v while a1 is in flight, want [b1]", got)
+				}
+			},
+		},
+		{
+			name: "fifo messages are deduplicated",
+			run: func(t *testing.T, mem *MemorySQS) {
+				mem.CreateQueue("orders.fifo", MemoryQueueOptions{})
+				sendTestMessage(t, mem, "orders.fifo", "a", "a")
+				sendTestMessage(t, mem, "orders.fifo", "a", "a")
+
+				if got := receiveBodies(t, mem, "orders.fifo"); !slices.Equal(got, []string{"a"}) {
+					t.Errorf("received %v, want a single a", got)
+				}
+			},
+		},
+		{
+			name: "standard queue rejects a message group",
+			run: func(t *testing.T, mem *MemorySQS) {
+				mem.CreateQueue("orders", MemoryQueueOptions{})
+				_, err := mem.SendMessage(context.Background(), &sqs.SendMessageInput{
+					QueueUrl:       aws.String(mem.QueueURL("orders")),
+					MessageBody:    aws.String("a"),
+					MessageGroupId: aws.String("a"),
+				})
+				if err == nil {
+					t.Error("SendMessage with a message group to a standard queue succeeded, want an error")
+				}
+			},
+		},
+		{
+			name: "unknown queue is reported",
+			run: func(t *testing.T, mem *MemorySQS) {
+				_, err := mem.GetQueueUrl(context.Background(), &sqs.GetQueueUrlInput{QueueName: aws.String("missing")})
+				var notFound *types.QueueDoesNotExist
+				if !errors.As(err, &notFound) {
+					t.Errorf("GetQueueUrl error = %v, want QueueDoesNotExist", err)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.run(t, NewMemorySQS())
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package pkgcommon
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SubscriberMetrics receives the counters of SQS subscribers, labelled by queue name
+type SubscriberMetrics interface {
+	IncReceived(queue string, n int)
+	IncProcessed(queue string)
+	IncFailed(queue string)
+	IncDeleted(queue string, n int)
+	ObserveHandlerLatency(queue string, d time.Duration)
+	AddInFlight(queue string, delta int)
+}
+
+// nopMetrics discards all metrics
+type nopMetrics struct{}
+
+func (nopMetrics) IncReceived(string, int)                     {}
+func (nopMetrics) IncProcessed(string)                         {}
+func (nopMetrics) IncFailed(string)                            {}
+func (nopMetrics) IncDeleted(string, int)                      {}
+func (nopMetrics) ObserveHandlerLatency(string, time.Duration) {}
+func (nopMetrics) AddInFlight(string, int)                     {}
+
+// latencyBuckets are the upper bounds in seconds of the handler latency histogram
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// queueMetrics holds the metrics of a single queue
+type queueMetrics struct {
+	received     int64
+	processed    int64
+	failed       int64
+	deleted      int64
+	inFlight     int64
+	latencyCount int64
+	latencySum   float64
+	buckets      []int64
+}
+
+// PrometheusMetrics keeps subscriber metrics in memory and serves them in the
+// Prometheus text exposition format. It can be mounted on a net/http mux
+type PrometheusMetrics struct {
+	queues map[string]*queueMetrics
+	mu     sync.Mutex
+}
+
+// NewPrometheusMetrics creates an empty PrometheusMetrics
+func NewPrometheusMetrics() *PrometheusMetrics {
+	return &PrometheusMetrics{
+		queues: make(map[string]*queueMetrics),
+	}
+}
+
+// queue returns the metrics of a queue, creating them on first use. The caller must hold mu
+func (m *PrometheusMetrics) queue(name string) *queueMetrics {
+	q, ok := m.queues[name]
+	if !ok {
+		q = &queueMetrics{buckets: make([]int64, len(latencyBuckets))}
+		m.queues[name] = q
+	}
+	return q
+}
+
+func (m *PrometheusMetrics) IncReceived(queue string, n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.queue(queue).received += int64(n)
+}
+
+func (m *PrometheusMetrics) IncProcessed(queue string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.queue(queue).processed++
+}
+
+func (m *PrometheusMetrics) IncFailed(queue string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.queue(queue).failed++
+}
+
+func (m *PrometheusMetrics) IncDeleted(queue string, n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.queue(queue).deleted += int64(n)
+}
+
+func (m *PrometheusMetrics) ObserveHandlerLatency(queue string, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	q := m.queue(queue)
+	seconds := d.Seconds()
+	q.latencyCount++
+	q.latencySum += seconds
+	for i, bound := range latencyBuckets {
+		if seconds <= bound {
+			q.buckets[i]++
+		}
+	}
+}
+
+func (m *PrometheusMetrics) AddInFlight(queue string, delta int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.queue(queue).inFlight += int64(delta)
+}
+
+// ServeHTTP writes the metrics in the Prometheus text exposition format
+func (m *PrometheusMetrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	fmt.Fprint(w, m.String())
+}
+
+// String renders the metrics in the Prometheus text exposition format
+func (m *PrometheusMetrics) String() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	names := make([]string, 0, len(m.queues))
+	for name := range m.queues {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	counter := func(metric, help string, value func(*queueMetrics) int64, kind string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", metric, help, metric, kind)
+		for _, name := range names {
+			fmt.Fprintf(&b, "%s{queue=%q} %d\n", metric, name, value(m.queues[name]))
+		}
+	}
+
+	counter("sqs_subscriber_messages_received_total", "Messages received from the queue.", func(q *queueMetrics) int64 { return q.received }, "counter")
+	counter("sqs_subscriber_messages_processed_total", "Messages acked by the handlers.", func(q *queueMetrics) int64 { return q.processed }, "counter")
+	counter("sqs_subscriber_messages_failed_total", "Messages not acked by the handlers.", func(q *queueMetrics) int64 { return q.failed }, "counter")
+	counter("sqs_subscriber_messages_deleted_total", "Messages deleted from the queue.", func(q *queueMetrics) int64 { return q.deleted }, "counter")
+	counter("sqs_subscriber_messages_in_flight", "Messages being processed by the handlers.", func(q *queueMetrics) int64 { return q.inFlight }, "gauge")
+
+	const latency = "sqs_subscriber_handler_duration_seconds"
+	fmt.Fprintf(&b, "# HELP %s Handler latency in seconds.\n# TYPE %s histogram\n", latency, latency)
+	for _, name := range names {
+		q := m.queues[name]
+		for i, bound := range latencyBuckets {
+			fmt.Fprintf(&b, "%s_bucket{queue=%q,le=\"%g\"} %d\n", latency, name, bound, q.buckets[i])
+		}
+		fmt.Fprintf(&b, "%s_bucket{queue=%q,le=\"+Inf\"} %d\n", latency, name, q.latencyCount)
+		fmt.Fprintf(&b, "%s_sum{queue=%q} %g\n", latency, name, q.latencySum)
+		fmt.Fprintf(&b, "%s_count{queue=%q} %d\n", latency, name, q.latencyCount)
+	}
+	return b.String()
+}
+
+// queueNameFromURL returns the last path segment of a queue URL
+func queueNameFromURL(queueURL string) string {
+	if i := strings.LastIndex(queueURL, "/"); i >= 0 {
+		return queueURL[i+1:]
+	}
+	return queueURL
+}
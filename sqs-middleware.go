@@ -0,0 +1,100 @@
+package pkgcommon
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"runtime/debug"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"gorm.io/gorm"
+)
+
+// Use registers middlewares around the handler chain. The first middleware is the
+// outermost; middlewares registered with Use wrap those given as subscriber options,
+// and panic recovery wraps them all.
+// Middlewares registered after Start apply from the next Start
+func (s *SQSSubscriber) Use(middlewares ...Middleware) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, middleware := range middlewares {
+		if middleware != nil {
+			s.middlewares = append(s.middlewares, middleware)
+		}
+	}
+}
+
+// buildChain wraps the handlers in the registered middlewares
+func (s *SQSSubscriber) buildChain() ResultHandler {
+	var middlewares []Middleware
+	if s.opts.recovery != nil {
+		middlewares = append(middlewares, s.opts.recovery)
+	}
+	middlewares = append(append(middlewares, s.middlewares...), s.opts.middlewares...)
+
+	chain := s.callHandlers
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		chain = middlewares[i](chain)
+	}
+	return chain
+}
+
+// WithRecover logs recovered handler panics to the error_logs table through NewLogger
+// in addition to the standard logger
+func WithRecover(db *gorm.DB) SubscriberOption {
+	return func(o *subscriberOptions) {
+		o.recovery = Recover(db)
+	}
+}
+
+// WithoutRecover disables the panic recovery that wraps the handler chain by default,
+// so a handler panic crashes the process
+func WithoutRecover() SubscriberOption {
+	return func(o *subscriberOptions) {
+		o.recovery = nil
+	}
+}
+
+// Recover returns a middleware that turns handler panics into errors, so a panic
+// does not kill the worker. Subscribers install Recover(nil) by default.
+// Panics are logged to the error_logs table through NewLogger when db is set,
+// and to the standard logger otherwise
+func Recover(db *gorm.DB) Middleware {
+	return func(next ResultHandler) ResultHandler {
+		return func(ctx context.Context, msg *types.Message) (res Result) {
+			defer func() {
+				r := recover()
+				if r == nil {
+					return
+				}
+
+				err := fmt.Errorf("panic in message handler: %v", r)
+				log.Printf("Recovered from panic processing message %s: %v\n%s", stringValue(msg.MessageId), r, debug.Stack())
+				if db != nil {
+					NewLogger(db, "SQS message handler panic", err).WithData(LogData{
+						Data: map[string]string{
+							"message_id": stringValue(msg.MessageId),
+							"body":       stringValue(msg.Body),
+						},
+					}).Log()
+				}
+				res = ResultFromError(err)
+			}()
+
+			return next(ctx, msg)
+		}
+	}
+}
+
+// callRecovered runs a user callback such as a dead-letter or poison handler,
+// turning a panic into an error so it does not kill the worker
+func callRecovered(name string, msg *types.Message, callback func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Recovered from panic in %s for message %s: %v\n%s", name, stringValue(msg.MessageId), r, debug.Stack())
+			err = fmt.Errorf("panic in %s: %v", name, r)
+		}
+	}()
+	return callback()
+}
@@ -0,0 +1,88 @@
+package pkgcommon
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+func TestSubscriberRecoversPanics(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []SubscriberOption
+		handler func(ctx context.Context, msg *types.Message) error
+	}{
+		{
+			name: "handler",
+			opts: []SubscriberOption{WithDeadLetterQueue("dlq", 2)},
+			handler: func(ctx context.Context, msg *types.Message) error {
+				panic("handler failed")
+			},
+		},
+		{
+			name: "dead-letter handler",
+			opts: []SubscriberOption{WithDeadLetterHandler(1, func(ctx context.Context, msg *types.Message, reason error) error {
+				panic("dead-letter handler failed")
+			})},
+			handler: func(ctx context.Context, msg *types.Message) error {
+				return Poison(nil)
+			},
+		},
+		{
+			name: "poison handler",
+			opts: []SubscriberOption{WithPoisonHandler(func(ctx context.Context, msg *types.Message, err error) error {
+				panic("poison handler failed")
+			})},
+			handler: func(ctx context.Context, msg *types.Message) error {
+				return Poison(nil)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mem := NewMemorySQS()
+			mem.CreateQueue("orders", MemoryQueueOptions{})
+			mem.CreateQueue("dlq", MemoryQueueOptions{})
+			sendTestMessage(t, mem, "orders", "payload", "")
+
+			var calls atomic.Int32
+			opts := append([]SubscriberOption{WithVisibilityTimeout(1), WithoutHeartbeat()}, tt.opts...)
+			sub := newTestSubscriber(t, mem, "orders", 1, opts...)
+			sub.AddContextHandler(func(ctx context.Context, msg *types.Message) error {
+				calls.Add(1)
+				return tt.handler(ctx, msg)
+			})
+			sub.Start()
+
+			// The worker survives the panic and processes the redelivery
+			waitFor(t, 5*time.Second, func() bool { return calls.Load() >= 2 })
+		})
+	}
+}
+
+func TestRecoveredHandlerPanicIsDeadLettered(t *testing.T) {
+	mem := NewMemorySQS()
+	mem.CreateQueue("orders", MemoryQueueOptions{})
+	mem.CreateQueue("dlq", MemoryQueueOptions{})
+	sendTestMessage(t, mem, "orders", "payload", "")
+
+	sub := newTestSubscriber(t, mem, "orders", 1, WithDeadLetterQueue("dlq", 1))
+	sub.AddContextHandler(func(ctx context.Context, msg *types.Message) error {
+		panic("handler failed")
+	})
+	sub.Start()
+
+	var dead []types.Message
+	waitFor(t, 3*time.Second, func() bool {
+		dead = append(dead, receiveAll(t, mem, "dlq")...)
+		return len(dead) > 0
+	})
+	if reason := stringValue(dead[0].MessageAttributes[DeadLetterReasonAttribute].StringValue); !strings.Contains(reason, "panic") {
+		t.Errorf("dead-letter reason = %q, want the recovered panic", reason)
+	}
+}
@@ -0,0 +1,152 @@
+package pkgcommon
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// tokenBucket is a token bucket rate limiter refilled continuously at rate tokens per second
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	mu     sync.Mutex
+}
+
+// newTokenBucket creates a full bucket
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	burst = max(burst, 1)
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// refill adds the tokens accrued since the last call. The caller must hold mu
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+// take removes up to n whole tokens. When no token is available it returns
+// zero and how long until the next token accrues
+func (b *tokenBucket) take(n int) (int, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill(time.Now())
+	if b.tokens < 1 {
+		return 0, time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+	}
+
+	taken := min(n, int(b.tokens))
+	b.tokens -= float64(taken)
+	return taken, 0
+}
+
+// acquire blocks until at least one token is available and takes up to n tokens.
+// Returns false when ctx is done first
+func (b *tokenBucket) acquire(ctx context.Context, n int) (int, bool) {
+	for {
+		taken, wait := b.take(n)
+		if taken > 0 {
+			return taken, true
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return 0, false
+		case <-timer.C:
+		}
+	}
+}
+
+// refund returns unused tokens to the bucket
+func (b *tokenBucket) refund(n int) {
+	if n <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.tokens = min(b.burst, b.tokens+float64(n))
+}
+
+// attributeBucketSweep is how often buckets that refilled completely are evicted
+const attributeBucketSweep = time.Minute
+
+// attributeRateLimiter keeps a token bucket per value of a message attribute
+type attributeRateLimiter struct {
+	attribute string
+	rate      float64
+	burst     int
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+	mu        sync.Mutex
+}
+
+// full reports whether the bucket has refilled to its burst, so it behaves like a new bucket
+func (b *tokenBucket) full(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill(now)
+	return b.tokens >= b.burst
+}
+
+// bucket returns the token bucket of an attribute value, creating it on first use.
+// Full buckets are evicted periodically so high-cardinality attributes do not grow the map
+func (l *attributeRateLimiter) bucket(value string) *tokenBucket {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now := time.Now(); now.Sub(l.lastSweep) >= attributeBucketSweep {
+		for v, b := range l.buckets {
+			if b.full(now) {
+				delete(l.buckets, v)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	b, ok := l.buckets[value]
+	if !ok {
+		b = newTokenBucket(l.rate, l.burst)
+		l.buckets[value] = b
+	}
+	return b
+}
+
+// attributeRateLimit returns a middleware that calls the handlers of each attribute value
+// at most rate times per second. A message whose value has no token left is retried once
+// a token accrues instead of holding a worker, so other values keep being processed.
+// Throttled messages count as a receive toward the dead-letter limit
+func attributeRateLimit(attribute string, rate float64, burst int) Middleware {
+	limiter := &attributeRateLimiter{
+		attribute: attribute,
+		rate:      rate,
+		burst:     burst,
+		buckets:   make(map[string]*tokenBucket),
+		lastSweep: time.Now(),
+	}
+
+	return func(next ResultHandler) ResultHandler {
+		return func(ctx context.Context, msg *types.Message) Result {
+			value, _ := MessageAttribute(msg, limiter.attribute)
+			if taken, wait := limiter.bucket(value).take(1); taken == 0 {
+				return Retry(wait, fmt.Errorf("rate limit for %s=%q exceeded", limiter.attribute, value))
+			}
+			return next(ctx, msg)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package pkgcommon
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// attributeBody returns a message body carrying the type attribute like an SNS envelope
+func attributeBody(id, value string) string {
+	return fmt.Sprintf(`{"id":%q,"MessageAttributes":{"type":{"Type":"String","Value":%q}}}`, id, value)
+}
+
+func TestAttributeRateLimitDoesNotHoldWorkers(t *testing.T) {
+	mem := NewMemorySQS()
+	mem.CreateQueue("orders", MemoryQueueOptions{})
+	throttled := []string{attributeBody("a1", "A"), attributeBody("a2", "A"), attributeBody("a3", "A")}
+	other := attributeBody("b1", "B")
+	for _, body := range append(throttled, other) {
+		sendTestMessage(t, mem, "orders", body, "")
+	}
+
+	calls := newHandlerCalls()
+	sub := newTestSubscriber(t, mem, "orders", 2, WithAttributeRateLimit("type", 1, 1))
+	sub.AddContextHandler(func(ctx context.Context, msg *types.Message) error {
+		calls.record(msg)
+		return nil
+	})
+	started := time.Now()
+	sub.Start()
+
+	// The other value is not stuck behind the throttled messages
+	waitFor(t, 500*time.Millisecond, func() bool { return len(calls.calls(other)) > 0 })
+
+	waitFor(t, 8*time.Second, func() bool {
+		visible, notVisible := queueDepth(t, mem, "orders")
+		return visible+notVisible == 0
+	})
+	if elapsed := time.Since(started); elapsed < 2*time.Second {
+		t.Errorf("three messages of one value processed in %s, want at least 2s at 1 per second", elapsed)
+	}
+	for _, body := range throttled {
+		if got := calls.calls(body); len(got) != 1 {
+			t.Errorf("handler called %d times for %s, want once", len(got), body)
+		}
+	}
+}
+
+func TestAttributeRateLimiterEvictsFullBuckets(t *testing.T) {
+	limiter := &attributeRateLimiter{
+		attribute: "typeId",
+		rate:      1000,
+		burst:     1,
+		buckets:   make(map[string]*tokenBucket),
+		lastSweep: time.Now(),
+	}
+	for i := 0; i < 100; i++ {
+		limiter.bucket(fmt.Sprintf("id-%d", i)).take(1)
+	}
+	if len(limiter.buckets) != 100 {
+		t.Fatalf("limiter has %d buckets, want 100", len(limiter.buckets))
+	}
+
+	// Every bucket refills within a few milliseconds at this rate
+	time.Sleep(10 * time.Millisecond)
+	limiter.lastSweep = time.Now().Add(-attributeBucketSweep)
+	limiter.bucket("id-new")
+	if len(limiter.buckets) != 1 {
+		t.Errorf("limiter has %d buckets after the sweep, want only the new one", len(limiter.buckets))
+	}
+}
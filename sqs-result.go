@@ -0,0 +1,192 @@
+package pkgcommon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// Action is what the subscriber does with a message after its handlers ran
+type Action int
+
+const (
+	// ActionAck deletes the message from the queue
+	ActionAck Action = iota
+	// ActionNack makes the message visible again immediately
+	ActionNack
+	// ActionRetry makes the message visible again after a delay
+	ActionRetry
+	// ActionDeadLetter moves the message to the dead-letter path
+	ActionDeadLetter
+	// ActionLeave keeps the message invisible until its visibility timeout expires
+	ActionLeave
+)
+
+func (a Action) String() string {
+	switch a {
+	case ActionAck:
+		return "ack"
+	case ActionNack:
+		return "nack"
+	case ActionRetry:
+		return "retry"
+	case ActionDeadLetter:
+		return "dead-letter"
+	case ActionLeave:
+		return "leave"
+	default:
+		return "unknown"
+	}
+}
+
+// Result is the outcome of processing a message
+type Result struct {
+	Action Action
+	Delay  time.Duration
+	Err    error
+
+	// useBackoff applies the subscriber backoff policy to a plain handler error
+	useBackoff bool
+}
+
+// ResultHandler processes a message and decides its outcome
+type ResultHandler func(ctx context.Context, msg *types.Message) Result
+
+// Ack deletes the message
+func Ack() Result {
+	return Result{Action: ActionAck}
+}
+
+// Nack makes the message visible again immediately
+func Nack(err error) Result {
+	return Result{Action: ActionNack, Err: err}
+}
+
+// Retry makes the message visible again after delay
+func Retry(delay time.Duration, err error) Result {
+	return Result{Action: ActionRetry, Delay: delay, Err: err}
+}
+
+// DeadLetter moves the message to the dead-letter path with reason attached
+func DeadLetter(reason error) Result {
+	if reason == nil {
+		reason = errors.New("dead-lettered by handler")
+	}
+	return Result{Action: ActionDeadLetter, Err: reason}
+}
+
+// Leave keeps the message invisible until its visibility timeout expires
+func Leave(err error) Result {
+	return Result{Action: ActionLeave, Err: err}
+}
+
+// ResultFromError maps the error returned by a handler onto a Result.
+// A nil error acks, a RetryableError retries after its delay, a PoisonMessageError
+// is dead-lettered, and any other error leaves the message for the subscriber
+// backoff policy or the visibility timeout
+func ResultFromError(err error) Result {
+	if err == nil {
+		return Ack()
+	}
+
+	var retryable *RetryableError
+	if errors.As(err, &retryable) {
+		return Retry(retryable.Delay, err)
+	}
+	var poison *PoisonMessageError
+	if errors.As(err, &poison) {
+		return DeadLetter(err)
+	}
+
+	res := Leave(err)
+	res.useBackoff = true
+	return res
+}
+
+// AdaptContextHandler wraps a ContextMessageHandler so it can be used where a ResultHandler is expected
+func AdaptContextHandler(handler ContextMessageHandler) ResultHandler {
+	return func(ctx context.Context, msg *types.Message) Result {
+		return ResultFromError(handler(ctx, msg))
+	}
+}
+
+// AddResultHandler registers a handler that decides the outcome of the message
+func (s *SQSSubscriber) AddResultHandler(handler ResultHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if handler == nil {
+		return
+	}
+	s.handlers = append(s.handlers, handler)
+}
+
+// callHandlers executes the handlers in order until one does not ack the message
+func (s *SQSSubscriber) callHandlers(ctx context.Context, msg *types.Message) Result {
+	for _, handler := range s.handlers {
+		res := handler(ctx, msg)
+		if res.Action != ActionAck {
+			if res.Err != nil {
+				log.Printf("Error processing message: %v", res.Err)
+			}
+			return res
+		}
+	}
+	return Ack()
+}
+
+// applyResult deletes, releases, retries or dead-letters the message as the result requires.
+// Messages that would be redelivered are dead-lettered once they exceed the receive limit
+func (s *SQSSubscriber) applyResult(ctx context.Context, msg *types.Message, res Result) {
+	switch res.Action {
+	case ActionAck:
+		s.deleteMessage(msg)
+		return
+	case ActionDeadLetter:
+		s.handleDeadLetterResult(ctx, msg, res.Err)
+		return
+	}
+
+	if s.exceededReceiveLimit(msg) {
+		reason := res.Err
+		if reason == nil {
+			reason = fmt.Errorf("message %s after %d receives", res.Action, receiveCount(msg))
+		}
+		s.deadLetter(ctx, msg, reason)
+		return
+	}
+	if msg.ReceiptHandle == nil {
+		return
+	}
+
+	switch res.Action {
+	case ActionNack:
+		s.changeVisibility(msg, 0)
+	case ActionRetry:
+		s.changeVisibility(msg, res.Delay)
+	case ActionLeave:
+		if res.useBackoff && s.opts.backoff != nil {
+			s.changeVisibility(msg, s.opts.backoff.Delay(receiveCount(msg)))
+		}
+	}
+}
+
+// handleDeadLetterResult sends poison messages down the poison path and other messages
+// to the dead-letter path. Without a dead-letter path the message is logged and deleted
+func (s *SQSSubscriber) handleDeadLetterResult(ctx context.Context, msg *types.Message, reason error) {
+	var poison *PoisonMessageError
+	if errors.As(reason, &poison) {
+		s.handlePoison(ctx, msg, reason)
+		return
+	}
+	if s.opts.deadLetterEnabled() {
+		s.deadLetter(ctx, msg, reason)
+		return
+	}
+	log.Printf("Discarding message %s without a dead-letter path: %v", stringValue(msg.MessageId), reason)
+	s.deleteMessage(msg)
+}
@@ -0,0 +1,134 @@
+package pkgcommon
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+func TestApplyResultDeadLettersResultsWithoutError(t *testing.T) {
+	tests := []struct {
+		name   string
+		result Result
+		reason string
+	}{
+		{"nack", Nack(nil), "message nack after 1 receives"},
+		{"leave", Leave(nil), "message leave after 1 receives"},
+		{"retry", Retry(time.Second, nil), "message retry after 1 receives"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mem := NewMemorySQS()
+			mem.CreateQueue("source", MemoryQueueOptions{})
+			mem.CreateQueue("dlq", MemoryQueueOptions{})
+			sendTestMessage(t, mem, "source", "payload", "")
+
+			sub := newTestSubscriber(t, mem, "source", 1, WithDeadLetterQueue("dlq", 1))
+			sub.AddResultHandler(func(ctx context.Context, msg *types.Message) Result {
+				return tt.result
+			})
+			sub.Start()
+
+			var dead []types.Message
+			waitFor(t, 3*time.Second, func() bool {
+				dead = append(dead, receiveAll(t, mem, "dlq")...)
+				return len(dead) > 0
+			})
+
+			reason := stringValue(dead[0].MessageAttributes[DeadLetterReasonAttribute].StringValue)
+			if !strings.Contains(reason, tt.reason) {
+				t.Errorf("dead-letter reason = %q, want %q", reason, tt.reason)
+			}
+			waitFor(t, 3*time.Second, func() bool {
+				visible, notVisible := queueDepth(t, mem, "source")
+				return visible+notVisible == 0
+			})
+		})
+	}
+}
+
+func TestApplyResultRedeliveryDelay(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []SubscriberOption
+		result  Result
+		minWait time.Duration
+		maxWait time.Duration
+	}{
+		{
+			name:    "nack is redelivered immediately",
+			result:  Nack(errors.New("failed")),
+			maxWait: 500 * time.Millisecond,
+		},
+		{
+			name:    "retry waits for the delay",
+			result:  Retry(1500*time.Millisecond, errors.New("failed")),
+			minWait: 1500 * time.Millisecond,
+		},
+		{
+			name:    "handler error waits for the backoff",
+			opts:    []SubscriberOption{WithBackoff(BackoffPolicy{Base: time.Second})},
+			result:  ResultFromError(errors.New("failed")),
+			minWait: time.Second,
+		},
+		{
+			name:    "retryable error waits for its delay",
+			opts:    []SubscriberOption{WithBackoff(BackoffPolicy{Base: time.Minute})},
+			result:  ResultFromError(RetryAfter(errors.New("failed"), time.Second)),
+			minWait: time.Second,
+			maxWait: 2500 * time.Millisecond,
+		},
+		{
+			name:    "leave waits for the visibility timeout",
+			result:  Leave(errors.New("failed")),
+			minWait: time.Second,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mem := NewMemorySQS()
+			mem.CreateQueue("orders", MemoryQueueOptions{})
+			sendTestMessage(t, mem, "orders", "payload", "")
+
+			var mu sync.Mutex
+			var received []time.Time
+			opts := append([]SubscriberOption{WithVisibilityTimeout(1), WithoutHeartbeat()}, tt.opts...)
+			sub := newTestSubscriber(t, mem, "orders", 1, opts...)
+			sub.AddResultHandler(func(ctx context.Context, msg *types.Message) Result {
+				mu.Lock()
+				defer mu.Unlock()
+				received = append(received, time.Now())
+				if receiveCount(msg) == 1 {
+					return tt.result
+				}
+				return Ack()
+			})
+			sub.Start()
+
+			waitFor(t, 5*time.Second, func() bool {
+				visible, notVisible := queueDepth(t, mem, "orders")
+				return visible+notVisible == 0
+			})
+
+			mu.Lock()
+			defer mu.Unlock()
+			if len(received) != 2 {
+				t.Fatalf("message received %d times, want 2", len(received))
+			}
+			wait := received[1].Sub(received[0])
+			if wait < tt.minWait {
+				t.Errorf("redelivered after %s, want at least %s", wait, tt.minWait)
+			}
+			if tt.maxWait > 0 && wait > tt.maxWait {
+				t.Errorf("redelivered after %s, want at most %s", wait, tt.maxWait)
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package pkgcommon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// ErrUnroutableMessage is returned by a MessageRouter when no handler matches a message
+// and no default handler is registered. The message is left on the queue
+var ErrUnroutableMessage = errors.New("unroutable message")
+
+// MessageRouter dispatches SQS messages to the handlers registered for the value
+// of a message attribute, such as the "type" attribute set by SNSNotification
+type MessageRouter struct {
+	attribute      string
+	routes         map[string][]ContextMessageHandler
+	fallback       ContextMessageHandler
+	dropUnroutable bool
+	mu             sync.RWMutex
+}
+
+// NewMessageRouter creates a router that dispatches on the given message attribute
+func NewMessageRouter(attribute string) *MessageRouter {
+	return &MessageRouter{
+		attribute: attribute,
+		routes:    make(map[string][]ContextMessageHandler),
+	}
+}
+
+// Handle registers handler for messages whose attribute equals value.
+// Handlers registered for the same value run in order and the first error stops the chain
+func (r *MessageRouter) Handle(value string, handler ContextMessageHandler) *MessageRouter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if handler != nil {
+		r.routes[value] = append(r.routes[value], handler)
+	}
+	return r
+}
+
+// Default registers the handler used when no route matches the attribute value
+func (r *MessageRouter) Default(handler ContextMessageHandler) *MessageRouter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.fallback = handler
+	return r
+}
+
+// DropUnroutable acknowledges unroutable messages so they are deleted
+// instead of returning ErrUnroutableMessage
+func (r *MessageRouter) DropUnroutable() *MessageRouter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.dropUnroutable = true
+	return r
+}
+
+// Route dispatches msg to its handlers; it satisfies ContextMessageHandler
+func (r *MessageRouter) Route(ctx context.Context, msg *types.Message) error {
+	value, _ := MessageAttribute(msg, r.attribute)
+
+	r.mu.RLock()
+	handlers := r.routes[value]
+	fallback := r.fallback
+	drop := r.dropUnroutable
+	r.mu.RUnlock()
+
+	if len(handlers) == 0 {
+		if fallback != nil {
+			return fallback(ctx, msg)
+		}
+		if drop {
+			log.Printf("Dropping unroutable message %s: %s=%q", stringValue(msg.MessageId), r.attribute, value)
+			return nil
+		}
+		return fmt.Errorf("%w: %s=%q", ErrUnroutableMessage, r.attribute, value)
+	}
+
+	for _, handler := range handlers {
+		if err := handler(ctx, msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddRouter registers the router as a handler of the subscriber
+func (s *SQSSubscriber) AddRouter(router *MessageRouter) {
+	if router == nil {
+		return
+	}
+	s.AddContextHandler(router.Route)
+}
+
+// MessageAttribute returns the string value of a message attribute.
+// It reads the SQS message attributes of raw deliveries and falls back to
+// the attributes in the SNS envelope of the message body
+func MessageAttribute(msg *types.Message, name string) (string, bool) {
+	if attr, ok := msg.MessageAttributes[name]; ok && attr.StringValue != nil {
+		return *attr.StringValue, true
+	}
+
+	if msg.Body == nil {
+		return "", false
+	}
+	attributes, err := MessageAttributesBodyParser(*msg.Body)
+	if err != nil {
+		return "", false
+	}
+	value, ok := attributes[name]
+	return value, ok
+}
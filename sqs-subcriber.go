@@ -5,17 +5,21 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
 	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
 )
 
 func init() {
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
-	LoadEnvFile()
+	// The environment may be provided without a .env file, as in tests against MemorySQS
+	if _, err := os.Stat(filepath.Join("./", ".env")); err == nil {
+		LoadEnvFile()
+	}
 }
 
 func isProductionEnv() bool {
@@ -23,110 +27,225 @@ func isProductionEnv() bool {
 	return env == "prod" || env == "production"
 }
 
-const (
-	maxWorkers        = 100
-	maxMessages       = 10
-	waitTimeSeconds   = 20
-	visibilityTimeout = 30
-)
-
 // SQSSubscriber provides a worker pool for processing SQS messages
 type SQSSubscriber struct {
-	client      *sqs.Client
-	queueURL    *string
-	workerCount int
-	handlers    []MessageHandler
-	wg          sync.WaitGroup
-	ctx         context.Context
-	cancel      context.CancelFunc
-	started     bool
-	mu          sync.Mutex
+	client        QueueClient
+	queueURL      *string
+	queueName     string
+	workerCount   int
+	handlers      []ResultHandler
+	middlewares   []Middleware
+	chain         ResultHandler
+	limiter       *tokenBucket
+	wg            sync.WaitGroup
+	ctx           context.Context
+	cancel        context.CancelFunc
+	pollCtx       context.Context
+	pollCancel    context.CancelFunc
+	draining      atomic.Bool
+	state         atomic.Int32
+	pauseMu       sync.Mutex
+	resumed       chan struct{}
+	mu            sync.Mutex
+	opts          subscriberOptions
+	deleter       *batchDeleter
+	fifo          bool
+	lanes         []chan fifoGroupBatch
+	jobs          chan dispatchJob
+	slots         *resizableSemaphore
+	running       *resizableSemaphore
+	polling       *resizableSemaphore
+	stats         subscriberStats
+	activeWorkers atomic.Int32
+	pollersAlive  atomic.Int32
+	workersAlive  atomic.Int32
+	lastPoll      atomic.Int64
+	pollingSince  atomic.Int64
+	blocked       atomic.Int32
+	poolWG        sync.WaitGroup
 }
 
 // MessageHandler defines the function signature for processing SQS messages
 type MessageHandler func(msg *types.Message) error
 
-func NewSQSSubscriber(queueName string, workerCount int) (*SQSSubscriber, error) {
-	if workerCount <= 0 || workerCount > maxWorkers {
-		return nil, fmt.Errorf("worker count must be between 1 and %d", maxWorkers)
+// ContextMessageHandler defines the function signature for processing SQS messages
+// with a per-message context. The context is derived from the subscriber context and
+// its deadline is tied to the message visibility timeout
+type ContextMessageHandler func(ctx context.Context, msg *types.Message) error
+
+// AdaptMessageHandler wraps a MessageHandler so it can be used where a ContextMessageHandler is expected
+func AdaptMessageHandler(handler MessageHandler) ContextMessageHandler {
+	return func(_ context.Context, msg *types.Message) error {
+		return handler(msg)
 	}
+}
 
-	cfg, err := config.LoadDefaultConfig(context.Background())
-	if err != nil {
-		return nil, err
+// NewSQSSubscriber creates a subscriber for queueName with workerCount handler workers.
+// Messages are received by separate pollers, see WithPollers.
+// The queue name is ignored when WithQueueURL is given
+func NewSQSSubscriber(queueName string, workerCount int, opts ...SubscriberOption) (*SQSSubscriber, error) {
+	options := defaultSubscriberOptions()
+	for _, opt := range opts {
+		opt(&options)
 	}
 
-	client := sqs.NewFromConfig(cfg)
-	ctx, cancel := context.WithCancel(context.Background())
+	if err := options.validate(workerCount); err != nil {
+		return nil, err
+	}
 
-	if !isProductionEnv() {
-		queueName = fmt.Sprintf("%s_%s", os.Getenv("APP_ENV"), queueName)
+	if options.dispatchBuffer == 0 {
+		options.dispatchBuffer = int(options.maxMessages)
 	}
 
-	accountID := os.Getenv("AWS_ACCOUNT_ID")
-	if accountID == "" {
-		cancel()
-		return nil, fmt.Errorf("AWS_ACCOUNT_ID environment variable is required")
+	client, err := options.sqsClient(context.Background())
+	if err != nil {
+		return nil, err
 	}
 
-	result, err := client.GetQueueUrl(ctx, &sqs.GetQueueUrlInput{
-		QueueOwnerAWSAccountId: &accountID,
-		QueueName:              &queueName,
-	})
+	ctx, cancel := context.WithCancel(context.Background())
+
+	queueURL, err := options.resolveQueueURL(ctx, client, queueName)
 	if err != nil {
 		cancel()
 		return nil, err
 	}
 
-	return &SQSSubscriber{
+	if options.deadLetterQueue != "" && options.deadLetterHandler == nil {
+		options.deadLetterURL, err = options.lookupQueueURL(ctx, client, options.deadLetterQueue)
+		if err != nil {
+			cancel()
+			return nil, fmt.Errorf("dead-letter queue %s: %w", options.deadLetterQueue, err)
+		}
+	}
+
+	subscriber := &SQSSubscriber{
 		client:      client,
-		queueURL:    result.QueueUrl,
+		queueURL:    queueURL,
+		queueName:   queueNameFromURL(*queueURL),
 		workerCount: workerCount,
 		ctx:         ctx,
 		cancel:      cancel,
-	}, nil
+		opts:        options,
+		fifo:        options.fifo || isFIFOQueue(*queueURL),
+	}
+	if options.rateLimit > 0 {
+		subscriber.limiter = newTokenBucket(options.rateLimit, options.rateBurst)
+	}
+	return subscriber, nil
 }
 
+// AddHandler registers a MessageHandler; it is adapted to a ContextMessageHandler
 func (s *SQSSubscriber) AddHandler(handler MessageHandler) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	if handler == nil {
+		return
+	}
+	s.AddContextHandler(AdaptMessageHandler(handler))
+}
 
+// AddContextHandler registers a handler that receives the per-message context.
+// Its error is mapped onto a Result with ResultFromError
+func (s *SQSSubscriber) AddContextHandler(handler ContextMessageHandler) {
 	if handler == nil {
 		return
 	}
-	s.handlers = append(s.handlers, handler)
+	s.AddResultHandler(AdaptContextHandler(handler))
 }
 
 func (s *SQSSubscriber) Start() {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if s.started {
+	switch s.State() {
+	case StateRunning, StatePaused:
 		return // Already started
+	case StateStopping:
+		log.Printf("Cannot start subscriber %s while a previous shutdown is draining", stringValue(s.queueURL))
+		return
 	}
 
 	if len(s.handlers) == 0 {
 		log.Println("Warning: No message handlers registered")
 	}
 
-	for i := 0; i < s.workerCount; i++ {
+	if s.opts.deleteFlush > 0 {
+		s.deleter = newBatchDeleter(s.client, s.queueURL, s.opts.deleteFlush, s.opts.deleteAttempts, func(n int) {
+			s.opts.metrics.IncDeleted(s.queueName, n)
+		})
+	}
+
+	// A stopped subscriber gets a fresh context so it can be restarted
+	if s.ctx.Err() != nil {
+		s.ctx, s.cancel = context.WithCancel(context.Background())
+	}
+	s.chain = s.buildChain()
+	s.stats = subscriberStats{}
+	s.draining.Store(false)
+	s.lastPoll.Store(0)
+	s.pollingSince.Store(time.Now().UnixNano())
+	s.pollCtx, s.pollCancel = context.WithCancel(s.ctx)
+	s.startPool()
+
+	for i := 0; i < s.pollerCount(s.maxActiveWorkers()); i++ {
+		s.wg.Add(1)
+		go s.startPoller()
+	}
+
+	if s.opts.autoscale != nil {
 		s.wg.Add(1)
-		go s.startWorker()
+		go s.runAutoscaler()
 	}
-	s.started = true
+	s.setState(StateRunning)
 }
 
+// Stop shuts the subscriber down gracefully without a deadline
 func (s *SQSSubscriber) Stop() {
+	if err := s.Shutdown(context.Background()); err != nil {
+		log.Printf("Error stopping subscriber: %v", err)
+	}
+}
+
+// Shutdown stops polling immediately and lets in-flight handlers finish and ack their
+// messages. Messages that were received but not started are released with visibility 0,
+// and buffered deletions are flushed. If ctx expires first, the handler contexts are
+// cancelled and the error of ctx is returned; the subscriber stays in StateStopping
+// until the drain completes. A stopped subscriber can be started again
+func (s *SQSSubscriber) Shutdown(ctx context.Context) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if !s.started {
-		return
+	if state := s.State(); state != StateRunning && state != StatePaused {
+		return nil
 	}
+	s.setState(StateStopping)
+
+	s.draining.Store(true)
+	s.pollCancel()
+	s.openPauseGate()
+
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
+
+		s.wg.Wait()
+		s.stopPool()
 
-	s.cancel()
-	s.wg.Wait()
-	s.started = false
+		// Flush buffered deletions once no worker can add to them
+		if s.deleter != nil {
+			s.deleter.Close()
+			s.deleter = nil
+		}
+		s.setState(StateStopped)
+	}()
+
+	select {
+	case <-drained:
+		s.cancel()
+		return nil
+	case <-ctx.Done():
+		// Cancel the handler contexts; the drain completes in the background
+		s.cancel()
+		return fmt.Errorf("shutdown of subscriber %s: %w", stringValue(s.queueURL), ctx.Err())
+	}
 }
 
 func (s *SQSSubscriber) Close() error {
@@ -135,40 +254,94 @@ func (s *SQSSubscriber) Close() error {
 	return nil
 }
 
-func (s *SQSSubscriber) startWorker() {
+// pollerCount returns the configured pollers, or one poller for every maxMessages workers
+func (s *SQSSubscriber) pollerCount(workers int) int {
+	if s.opts.pollers > 0 {
+		return s.opts.pollers
+	}
+	return (workers + int(s.opts.maxMessages) - 1) / int(s.opts.maxMessages)
+}
+
+// startPoller receives messages for as long as dispatch slots are free
+func (s *SQSSubscriber) startPoller() {
 	defer s.wg.Done()
 
+	s.pollersAlive.Add(1)
+	defer s.pollersAlive.Add(-1)
+
 	for {
-		select {
-		case <-s.ctx.Done():
-			log.Println("Worker shutting down gracefully")
+		if !s.waitWhilePaused() {
+			log.Println("Poller shutting down gracefully")
+			return
+		}
+
+		// Only the pollers needed by the active workers receive at the same time
+		if _, ok := s.polling.acquire(s.pollCtx, 1); !ok {
+			log.Println("Poller shutting down gracefully")
+			return
+		}
+		s.poll()
+		s.polling.release(1)
+	}
+}
+
+// poll runs a single receive call and dispatches the received messages
+func (s *SQSSubscriber) poll() {
+	// A poller waiting for free slots or rate limit tokens is backpressured, not stale
+	s.blocked.Add(1)
+	slots, ok := s.acquireSlots()
+	s.blocked.Add(-1)
+	if !ok {
+		return
+	}
+
+	if s.limiter != nil {
+		// Only receive as many messages as the rate limit allows
+		s.blocked.Add(1)
+		tokens, ok := s.limiter.acquire(s.pollCtx, slots)
+		s.blocked.Add(-1)
+		if !ok {
+			s.releaseSlots(slots)
+			return
+		}
+		s.releaseSlots(slots - tokens)
+		slots = tokens
+	}
+
+	messages, err := s.receiveMessages(int32(slots))
+	receivedAt := time.Now()
+	if err != nil {
+		s.releaseSlots(slots)
+		s.refundTokens(slots)
+		if s.pollCtx.Err() != nil {
 			return
-		default:
-			messages, err := s.receiveMessages()
-			if err != nil {
-				log.Printf("Error receiving messages: %v", err)
-				select {
-				case <-s.ctx.Done():
-					return
-				case <-time.After(time.Second):
-					continue
-				}
-			}
-
-			for _, msg := range messages.Messages {
-				select {
-				case <-s.ctx.Done():
-					return
-				default:
-					s.processMessage(&msg)
-				}
-			}
 		}
+		log.Printf("Error receiving messages: %v", err)
+		select {
+		case <-s.pollCtx.Done():
+		case <-time.After(time.Second):
+		}
+		return
 	}
+
+	s.lastPoll.Store(receivedAt.UnixNano())
+	s.stats.recordReceive(len(messages.Messages))
+	s.opts.metrics.IncReceived(s.queueName, len(messages.Messages))
+	s.releaseSlots(slots - len(messages.Messages))
+	s.refundTokens(slots - len(messages.Messages))
+	s.dispatch(messages.Messages, receivedAt)
 }
 
-func (s *SQSSubscriber) receiveMessages() (*sqs.ReceiveMessageOutput, error) {
-	return s.client.ReceiveMessage(s.ctx, &sqs.ReceiveMessageInput{
+// refundTokens returns rate limit tokens that were not used by a receive call
+func (s *SQSSubscriber) refundTokens(n int) {
+	if s.limiter != nil {
+		s.limiter.refund(n)
+	}
+}
+
+// receiveMessages long-polls for up to maxMessages messages
+func (s *SQSSubscriber) receiveMessages(maxMessages int32) (*sqs.ReceiveMessageOutput, error) {
+	return s.client.ReceiveMessage(s.pollCtx, &sqs.ReceiveMessageInput{
 		AttributeNames: []types.QueueAttributeName{
 			types.QueueAttributeNameAll,
 		},
@@ -177,33 +350,78 @@ func (s *SQSSubscriber) receiveMessages() (*sqs.ReceiveMessageOutput, error) {
 		},
 		QueueUrl:            s.queueURL,
 		MaxNumberOfMessages: maxMessages,
-		WaitTimeSeconds:     waitTimeSeconds,
-		VisibilityTimeout:   visibilityTimeout,
+		WaitTimeSeconds:     s.opts.waitTimeSeconds,
+		VisibilityTimeout:   s.opts.visibilityTimeout,
 	})
 }
 
-func (s *SQSSubscriber) processMessage(msg *types.Message) {
-	var processError error
+// visibilityExpiry returns the moment a message received at receivedAt becomes visible
+// again on the queue, including the maximum extension granted by the heartbeat
+func (s *SQSSubscriber) visibilityExpiry(receivedAt time.Time) time.Time {
+	expiry := receivedAt.Add(s.opts.visibility())
+	if s.opts.heartbeatEnabled() {
+		expiry = expiry.Add(s.opts.maxExtension)
+	}
+	return expiry
+}
+
+// messageContext derives the per-message context from the subscriber context.
+// The deadline is the moment the message becomes visible again on the queue
+func (s *SQSSubscriber) messageContext(receivedAt time.Time) (context.Context, context.CancelFunc) {
+	return context.WithDeadline(s.ctx, s.visibilityExpiry(receivedAt))
+}
 
-	// Execute all handlers for the message
-	for _, handler := range s.handlers {
-		if err := handler(msg); err != nil {
-			processError = err
-			log.Printf("Error processing message: %v", err)
-			break
-		}
+// processMessage runs the handlers for a message and applies their result.
+// hb is the heartbeat started when the message was dispatched
+func (s *SQSSubscriber) processMessage(source *types.Message, receivedAt time.Time, hb *heartbeat) Result {
+	ctx, cancel := s.messageContext(receivedAt)
+	defer cancel()
+
+	// Handlers see msg while source keeps the message as it was received
+	msg := source
+	if s.opts.unwrapSNS {
+		ctx, msg = unwrapSNSEnvelope(ctx, source)
 	}
 
-	// If message was processed successfully, delete it
-	if processError == nil {
-		if msg.ReceiptHandle != nil {
-			_, err := s.client.DeleteMessage(s.ctx, &sqs.DeleteMessageInput{
-				QueueUrl:      s.queueURL,
-				ReceiptHandle: msg.ReceiptHandle,
-			})
-			if err != nil {
-				log.Printf("Error deleting message: %v", err)
-			}
-		}
+	s.opts.metrics.AddInFlight(s.queueName, 1)
+	started := time.Now()
+	res := s.chain(ctx, msg)
+	latency := time.Since(started)
+	s.opts.metrics.AddInFlight(s.queueName, -1)
+
+	s.stats.recordHandled(latency)
+	s.opts.metrics.ObserveHandlerLatency(s.queueName, latency)
+	if res.Action == ActionAck {
+		s.opts.metrics.IncProcessed(s.queueName)
+	} else {
+		s.opts.metrics.IncFailed(s.queueName)
+	}
+
+	// Stop extending visibility before the message is deleted or released
+	hb.Stop()
+
+	s.applyResult(ctx, source, res)
+	return res
+}
+
+// deleteMessage removes a processed message from the queue, through the batch deleter when enabled
+func (s *SQSSubscriber) deleteMessage(msg *types.Message) {
+	if msg.ReceiptHandle == nil {
+		return
+	}
+
+	if s.deleter != nil {
+		s.deleter.Add(msg)
+		return
+	}
+
+	_, err := s.client.DeleteMessage(s.ctx, &sqs.DeleteMessageInput{
+		QueueUrl:      s.queueURL,
+		ReceiptHandle: msg.ReceiptHandle,
+	})
+	if err != nil {
+		log.Printf("Error deleting message: %v", err)
+		return
 	}
+	s.opts.metrics.IncDeleted(s.queueName, 1)
 }
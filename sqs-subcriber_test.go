@@ -0,0 +1,137 @@
+package pkgcommon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+func TestShutdown(t *testing.T) {
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		// waitForCancel makes the running handler return only when its context is cancelled
+		waitForCancel bool
+		wantErr       bool
+		wantAcked     int
+	}{
+		{
+			name:      "in-flight handler finishes and unstarted messages are released",
+			timeout:   5 * time.Second,
+			wantAcked: 1,
+		},
+		{
+			name:          "expired deadline cancels the in-flight handler",
+			timeout:       100 * time.Millisecond,
+			waitForCancel: true,
+			wantErr:       true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mem := NewMemorySQS()
+			mem.CreateQueue("orders", MemoryQueueOptions{})
+			for i := 0; i < 5; i++ {
+				sendTestMessage(t, mem, "orders", fmt.Sprintf("message-%d", i), "")
+			}
+
+			started := make(chan struct{}, 5)
+			finish := make(chan struct{})
+			cancelled := make(chan struct{})
+			calls := newHandlerCalls()
+			sub := newTestSubscriber(t, mem, "orders", 1, WithVisibilityTimeout(30))
+			sub.AddContextHandler(func(ctx context.Context, msg *types.Message) error {
+				calls.record(msg)
+				started <- struct{}{}
+				if tt.waitForCancel {
+					<-ctx.Done()
+					close(cancelled)
+					return ctx.Err()
+				}
+				<-finish
+				return nil
+			})
+			sub.Start()
+
+			<-started
+			// Every message is received before the handler finishes
+			waitFor(t, 3*time.Second, func() bool {
+				visible, _ := queueDepth(t, mem, "orders")
+				return visible == 0
+			})
+
+			ctx, cancel := context.WithTimeout(context.Background(), tt.timeout)
+			defer cancel()
+			shutdown := make(chan error, 1)
+			go func() { shutdown <- sub.Shutdown(ctx) }()
+			if !tt.waitForCancel {
+				waitFor(t, time.Second, func() bool { return sub.State() == StateStopping })
+				close(finish)
+			}
+
+			err := <-shutdown
+			if gotErr := err != nil; gotErr != tt.wantErr {
+				t.Fatalf("Shutdown error = %v, want error %t", err, tt.wantErr)
+			}
+			if tt.waitForCancel {
+				select {
+				case <-cancelled:
+				case <-time.After(time.Second):
+					t.Fatal("handler context was not cancelled")
+				}
+			}
+			waitFor(t, 3*time.Second, func() bool { return sub.State() == StateStopped })
+
+			if got := len(calls.processed()); got != 1 {
+				t.Errorf("%d messages were started, want 1", got)
+			}
+			visible, _ := queueDepth(t, mem, "orders")
+			if want := 5 - tt.wantAcked - 1; visible < want {
+				t.Errorf("%d messages are visible after shutdown, want the %d unstarted messages released", visible, want)
+			}
+		})
+	}
+}
+
+func TestShutdownReleasesFIFOGroup(t *testing.T) {
+	mem := NewMemorySQS()
+	mem.CreateQueue("orders.fifo", MemoryQueueOptions{})
+	for _, body := range []string{"a", "b", "c"} {
+		sendTestMessage(t, mem, "orders.fifo", body, "car-1")
+	}
+
+	started := make(chan struct{})
+	finish := make(chan struct{})
+	sub := newTestSubscriber(t, mem, "orders.fifo", 1, WithVisibilityTimeout(30))
+	sub.AddContextHandler(func(ctx context.Context, msg *types.Message) error {
+		if stringValue(msg.Body) == "a" {
+			close(started)
+			<-finish
+			return nil
+		}
+		return errors.New("processed after shutdown")
+	})
+	sub.Start()
+
+	<-started
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- sub.Shutdown(context.Background()) }()
+	waitFor(t, time.Second, func() bool { return sub.State() == StateStopping })
+	close(finish)
+	if err := <-shutdown; err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+
+	messages := receiveAll(t, mem, "orders.fifo")
+	if len(messages) != 2 || stringValue(messages[0].Body) != "b" {
+		t.Fatalf("received %d messages after shutdown, want the released b and c", len(messages))
+	}
+	if count := receiveCount(&messages[0]); count != 2 {
+		t.Errorf("message b receive count = %d, want 2", count)
+	}
+}
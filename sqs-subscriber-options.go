@@ -0,0 +1,391 @@
+package pkgcommon
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+)
+
+const (
+	defaultMaxWorkers        = 100
+	defaultMaxMessages       = 10
+	defaultWaitTimeSeconds   = 20
+	defaultVisibilityTimeout = 30
+	defaultHeartbeatInterval = 10 * time.Second
+	defaultMaxExtension      = 15 * time.Minute
+)
+
+// SubscriberOption configures an SQSSubscriber
+type SubscriberOption func(*subscriberOptions)
+
+// subscriberOptions contains all options for an SQSSubscriber
+type subscriberOptions struct {
+	maxWorkers        int
+	maxMessages       int32
+	waitTimeSeconds   int32
+	visibilityTimeout int32
+	heartbeatInterval time.Duration
+	maxExtension      time.Duration
+	deleteFlush       time.Duration
+	deleteAttempts    int
+	client            QueueClient
+	awsConfig         *aws.Config
+	queueURL          string
+	accountID         string
+	queueNamePrefix   *string
+	unwrapSNS         bool
+	poisonHandler     PoisonHandler
+	maxReceiveCount   int
+	deadLetterQueue   string
+	deadLetterURL     *string
+	deadLetterHandler DeadLetterHandler
+	backoff           *BackoffPolicy
+	fifo              bool
+	pollers           int
+	dispatchBuffer    int
+	middlewares       []Middleware
+	recovery          Middleware
+	rateLimit         float64
+	rateBurst         int
+	autoscale         *AutoscaleOptions
+	metrics           SubscriberMetrics
+}
+
+// defaultSubscriberOptions returns the options used when none are given
+func defaultSubscriberOptions() subscriberOptions {
+	return subscriberOptions{
+		maxWorkers:        defaultMaxWorkers,
+		maxMessages:       defaultMaxMessages,
+		waitTimeSeconds:   defaultWaitTimeSeconds,
+		visibilityTimeout: defaultVisibilityTimeout,
+		heartbeatInterval: defaultHeartbeatInterval,
+		maxExtension:      defaultMaxExtension,
+		deleteFlush:       defaultDeleteFlush,
+		deleteAttempts:    defaultDeleteAttempts,
+		metrics:           nopMetrics{},
+		recovery:          Recover(nil),
+	}
+}
+
+// WithMaxWorkers sets the upper bound for the worker count of the subscriber
+func WithMaxWorkers(n int) SubscriberOption {
+	return func(o *subscriberOptions) {
+		o.maxWorkers = n
+	}
+}
+
+// WithMaxMessages sets how many messages are requested per receive call (1-10)
+func WithMaxMessages(n int32) SubscriberOption {
+	return func(o *subscriberOptions) {
+		o.maxMessages = n
+	}
+}
+
+// WithWaitTimeSeconds sets the long polling duration of a receive call (0-20)
+func WithWaitTimeSeconds(seconds int32) SubscriberOption {
+	return func(o *subscriberOptions) {
+		o.waitTimeSeconds = seconds
+	}
+}
+
+// WithVisibilityTimeout sets the visibility timeout in seconds requested for received messages (1-43200)
+func WithVisibilityTimeout(seconds int32) SubscriberOption {
+	return func(o *subscriberOptions) {
+		o.visibilityTimeout = seconds
+	}
+}
+
+// WithHeartbeat sets how often the visibility of an in-flight message is extended
+// and the maximum total extension beyond the visibility timeout.
+// An interval that is not shorter than the visibility timeout is reduced to a third of it.
+// A zero interval disables the heartbeat
+func WithHeartbeat(interval, maxExtension time.Duration) SubscriberOption {
+	return func(o *subscriberOptions) {
+		o.heartbeatInterval = interval
+		o.maxExtension = maxExtension
+	}
+}
+
+// WithoutHeartbeat disables automatic visibility extension
+func WithoutHeartbeat() SubscriberOption {
+	return WithHeartbeat(0, 0)
+}
+
+// WithDeleteBatching sets how long processed messages are buffered before they are
+// deleted with DeleteMessageBatch, and how many times a failed deletion is attempted.
+// A zero flush interval deletes every message individually
+func WithDeleteBatching(flushInterval time.Duration, maxAttempts int) SubscriberOption {
+	return func(o *subscriberOptions) {
+		o.deleteFlush = flushInterval
+		if maxAttempts > 0 {
+			o.deleteAttempts = maxAttempts
+		}
+	}
+}
+
+// WithSQSClient uses an existing SQS client, or another QueueClient such as MemorySQS,
+// instead of loading the default AWS config
+func WithSQSClient(client QueueClient) SubscriberOption {
+	return func(o *subscriberOptions) {
+		o.client = client
+	}
+}
+
+// WithAWSConfig builds the SQS client from cfg instead of loading the default AWS config
+func WithAWSConfig(cfg aws.Config) SubscriberOption {
+	return func(o *subscriberOptions) {
+		o.awsConfig = &cfg
+	}
+}
+
+// WithQueueURL consumes the queue at url, skipping the queue name lookup
+func WithQueueURL(url string) SubscriberOption {
+	return func(o *subscriberOptions) {
+		o.queueURL = url
+	}
+}
+
+// WithAccountID sets the AWS account that owns the queue, overriding AWS_ACCOUNT_ID
+func WithAccountID(accountID string) SubscriberOption {
+	return func(o *subscriberOptions) {
+		o.accountID = accountID
+	}
+}
+
+// WithQueueNamePrefix sets the prefix joined to the queue name with an underscore,
+// overriding the APP_ENV prefix used outside production. An empty prefix disables it
+func WithQueueNamePrefix(prefix string) SubscriberOption {
+	return func(o *subscriberOptions) {
+		o.queueNamePrefix = &prefix
+	}
+}
+
+// WithSNSUnwrap unwraps the SNS envelope of fanned-out messages before handlers run.
+// Handlers see the notification message as the body, the envelope attributes as
+// message attributes, and the envelope itself through SNSEnvelopeFromContext
+func WithSNSUnwrap() SubscriberOption {
+	return func(o *subscriberOptions) {
+		o.unwrapSNS = true
+	}
+}
+
+// WithPoisonHandler sets the handler for messages that fail with a PoisonMessageError,
+// such as typed handler payloads that cannot be decoded
+func WithPoisonHandler(handler PoisonHandler) SubscriberOption {
+	return func(o *subscriberOptions) {
+		o.poisonHandler = handler
+	}
+}
+
+// WithDeadLetterQueue moves messages that failed maxReceiveCount times to the named queue,
+// with failure metadata attached, and deletes them from the source queue.
+// The queue name is resolved like the source queue name
+func WithDeadLetterQueue(queueName string, maxReceiveCount int) SubscriberOption {
+	return func(o *subscriberOptions) {
+		o.deadLetterQueue = queueName
+		o.maxReceiveCount = maxReceiveCount
+	}
+}
+
+// WithDeadLetterHandler passes messages that failed maxReceiveCount times to handler
+// instead of a dead-letter queue. The message is deleted when handler returns nil
+func WithDeadLetterHandler(maxReceiveCount int, handler DeadLetterHandler) SubscriberOption {
+	return func(o *subscriberOptions) {
+		o.deadLetterHandler = handler
+		o.maxReceiveCount = maxReceiveCount
+	}
+}
+
+// WithBackoff retries failed messages after a delay computed from their receive count
+// instead of waiting for the visibility timeout. Handlers can override the delay by
+// returning RetryAfter
+func WithBackoff(policy BackoffPolicy) SubscriberOption {
+	return func(o *subscriberOptions) {
+		o.backoff = &policy
+	}
+}
+
+// WithFIFO processes messages in order per MessageGroupId. Each group is pinned to
+// one worker and different groups run in parallel. FIFO mode is enabled automatically
+// for queue URLs ending in .fifo
+func WithFIFO() SubscriberOption {
+	return func(o *subscriberOptions) {
+		o.fifo = true
+	}
+}
+
+// WithPollers sets how many goroutines long-poll the queue. By default there is
+// one poller for every maxMessages handler workers
+func WithPollers(n int) SubscriberOption {
+	return func(o *subscriberOptions) {
+		o.pollers = n
+	}
+}
+
+// WithDispatchBuffer sets how many received messages may wait for a free handler worker.
+// Pollers stop receiving while the workers and the buffer are full. Defaults to maxMessages
+func WithDispatchBuffer(n int) SubscriberOption {
+	return func(o *subscriberOptions) {
+		o.dispatchBuffer = n
+	}
+}
+
+// WithIdempotency acks duplicate deliveries without running the handlers,
+// recording completed messages in cache. See Idempotent
+func WithIdempotency(cache AppCache, options IdempotencyOptions) SubscriberOption {
+	return func(o *subscriberOptions) {
+		o.middlewares = append(o.middlewares, Idempotent(cache, options))
+	}
+}
+
+// WithRateLimit calls the handlers at most rate times per second across all pollers,
+// with bursts of up to burst messages. Pollers only receive as many messages as there
+// are tokens, so polling slows down rather than receiving messages that would wait
+func WithRateLimit(rate float64, burst int) SubscriberOption {
+	return func(o *subscriberOptions) {
+		o.rateLimit = rate
+		o.rateBurst = burst
+	}
+}
+
+// WithAttributeRateLimit calls the handlers at most rate times per second for each value
+// of a message attribute, such as the "type" attribute set by SNSNotification.
+// The value is only known once a message is received, so instead of slowing polling
+// a throttled message is made visible again when its value has a token
+func WithAttributeRateLimit(attribute string, rate float64, burst int) SubscriberOption {
+	return func(o *subscriberOptions) {
+		if rate > 0 {
+			o.middlewares = append(o.middlewares, attributeRateLimit(attribute, rate, burst))
+		}
+	}
+}
+
+// WithMetrics reports the subscriber counters to metrics, such as a PrometheusMetrics
+func WithMetrics(metrics SubscriberMetrics) SubscriberOption {
+	return func(o *subscriberOptions) {
+		if metrics != nil {
+			o.metrics = metrics
+		}
+	}
+}
+
+// validate checks the options against the SQS limits
+func (o subscriberOptions) validate(workerCount int) error {
+	if o.maxWorkers <= 0 {
+		return fmt.Errorf("max workers must be positive")
+	}
+	if workerCount <= 0 || workerCount > o.maxWorkers {
+		return fmt.Errorf("worker count must be between 1 and %d", o.maxWorkers)
+	}
+	if o.maxMessages < 1 || o.maxMessages > 10 {
+		return fmt.Errorf("max messages must be between 1 and 10")
+	}
+	if o.waitTimeSeconds < 0 || o.waitTimeSeconds > 20 {
+		return fmt.Errorf("wait time seconds must be between 0 and 20")
+	}
+	// A zero visibility timeout would make every received message expired on arrival
+	if o.visibilityTimeout < 1 || time.Duration(o.visibilityTimeout)*time.Second > maxVisibilityTimeout {
+		return fmt.Errorf("visibility timeout must be between 1 and %d seconds", int(maxVisibilityTimeout.Seconds()))
+	}
+	if o.pollers < 0 {
+		return fmt.Errorf("pollers must not be negative")
+	}
+	if o.dispatchBuffer < 0 {
+		return fmt.Errorf("dispatch buffer must not be negative")
+	}
+	if o.rateLimit < 0 {
+		return fmt.Errorf("rate limit must not be negative")
+	}
+	if o.maxReceiveCount < 0 {
+		return fmt.Errorf("max receive count must not be negative")
+	}
+	return o.validateAutoscale(workerCount)
+}
+
+// deadLetterEnabled reports whether failed messages have a dead-letter path
+func (o subscriberOptions) deadLetterEnabled() bool {
+	return o.deadLetterHandler != nil || o.deadLetterURL != nil
+}
+
+// heartbeatEnabled reports whether visibility extension is active
+func (o subscriberOptions) heartbeatEnabled() bool {
+	return o.heartbeatInterval > 0 && o.maxExtension > 0
+}
+
+// heartbeatPeriod returns the heartbeat interval, reduced to a third of the visibility
+// timeout when it would not extend the visibility before the message reappears
+func (o subscriberOptions) heartbeatPeriod() time.Duration {
+	if o.heartbeatInterval >= o.visibility() {
+		return o.visibility() / 3
+	}
+	return o.heartbeatInterval
+}
+
+// visibility returns the visibility timeout as a duration
+func (o subscriberOptions) visibility() time.Duration {
+	return time.Duration(o.visibilityTimeout) * time.Second
+}
+
+// sqsClient returns the configured client, building one from the AWS config when needed
+func (o subscriberOptions) sqsClient(ctx context.Context) (QueueClient, error) {
+	if o.client != nil {
+		return o.client, nil
+	}
+	if o.awsConfig != nil {
+		return sqs.NewFromConfig(*o.awsConfig), nil
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return sqs.NewFromConfig(cfg), nil
+}
+
+// queueName applies the configured prefix, or the APP_ENV prefix outside production
+func (o subscriberOptions) queueName(name string) string {
+	if o.queueNamePrefix != nil {
+		if *o.queueNamePrefix == "" {
+			return name
+		}
+		return fmt.Sprintf("%s_%s", *o.queueNamePrefix, name)
+	}
+	if !isProductionEnv() {
+		return fmt.Sprintf("%s_%s", os.Getenv("APP_ENV"), name)
+	}
+	return name
+}
+
+// resolveQueueURL returns the explicit queue URL or looks it up by queue name
+func (o subscriberOptions) resolveQueueURL(ctx context.Context, client QueueClient, name string) (*string, error) {
+	if o.queueURL != "" {
+		return aws.String(o.queueURL), nil
+	}
+	return o.lookupQueueURL(ctx, client, name)
+}
+
+// lookupQueueURL resolves the URL of the queue with the given name
+func (o subscriberOptions) lookupQueueURL(ctx context.Context, client QueueClient, name string) (*string, error) {
+	accountID := o.accountID
+	if accountID == "" {
+		accountID = os.Getenv("AWS_ACCOUNT_ID")
+	}
+	if accountID == "" {
+		return nil, fmt.Errorf("AWS_ACCOUNT_ID environment variable is required")
+	}
+
+	queueName := o.queueName(name)
+	result, err := client.GetQueueUrl(ctx, &sqs.GetQueueUrlInput{
+		QueueOwnerAWSAccountId: &accountID,
+		QueueName:              &queueName,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.QueueUrl, nil
+}
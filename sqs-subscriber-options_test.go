@@ -0,0 +1,69 @@
+package pkgcommon
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSubscriberOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []SubscriberOption
+		workers int
+		wantErr string
+	}{
+		{name: "defaults", workers: 1},
+		{name: "zero visibility timeout", opts: []SubscriberOption{WithVisibilityTimeout(0)}, workers: 1, wantErr: "visibility timeout"},
+		{name: "zero visibility timeout without heartbeat", opts: []SubscriberOption{WithVisibilityTimeout(0), WithoutHeartbeat()}, workers: 1, wantErr: "visibility timeout"},
+		{name: "visibility timeout above the SQS limit", opts: []SubscriberOption{WithVisibilityTimeout(43201)}, workers: 1, wantErr: "visibility timeout"},
+		{name: "one second visibility timeout", opts: []SubscriberOption{WithVisibilityTimeout(1)}, workers: 1},
+		{name: "too many messages", opts: []SubscriberOption{WithMaxMessages(11)}, workers: 1, wantErr: "max messages"},
+		{name: "too many workers", opts: []SubscriberOption{WithMaxWorkers(2)}, workers: 3, wantErr: "worker count"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := defaultSubscriberOptions()
+			for _, opt := range tt.opts {
+				opt(&o)
+			}
+
+			err := o.validate(tt.workers)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("validate() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("validate() = %v, want an error about %s", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHeartbeatPeriod(t *testing.T) {
+	tests := []struct {
+		name       string
+		visibility int32
+		interval   time.Duration
+		want       time.Duration
+	}{
+		{"shorter than the visibility timeout", 30, 10 * time.Second, 10 * time.Second},
+		{"equal to the visibility timeout", 30, 30 * time.Second, 10 * time.Second},
+		{"longer than the visibility timeout", 1, 10 * time.Second, time.Second / 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := defaultSubscriberOptions()
+			WithVisibilityTimeout(tt.visibility)(&o)
+			WithHeartbeat(tt.interval, time.Minute)(&o)
+
+			if got := o.heartbeatPeriod(); got != tt.want {
+				t.Errorf("heartbeatPeriod() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package pkgcommon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// PoisonMessageError marks a message that can never be processed successfully,
+// such as one whose body cannot be decoded. Poison messages are not retried
+type PoisonMessageError struct {
+	Err error
+}
+
+func (e *PoisonMessageError) Error() string {
+	return fmt.Sprintf("poison message: %v", e.Err)
+}
+
+func (e *PoisonMessageError) Unwrap() error {
+	return e.Err
+}
+
+// Poison wraps err so the subscriber sends the message to the poison-message path
+func Poison(err error) error {
+	return &PoisonMessageError{Err: err}
+}
+
+// PoisonHandler receives messages that failed with a PoisonMessageError.
+// The message is deleted when it returns nil and left on the queue otherwise
+type PoisonHandler func(ctx context.Context, msg *types.Message, err error) error
+
+// Meta describes the SQS message a typed handler payload was decoded from
+type Meta struct {
+	MessageID    string
+	ReceiveCount int
+	Attributes   map[string]string
+	Envelope     *SNSEnvelope
+	Message      *types.Message
+}
+
+// TypedHandler adapts a handler of a decoded payload to a ContextMessageHandler.
+// The payload is decoded from the SNS "body" attribute when present and from the
+// message body otherwise. Decode failures are returned as a PoisonMessageError
+func TypedHandler[T any](handler func(ctx context.Context, payload T, meta Meta) error) ContextMessageHandler {
+	return func(ctx context.Context, msg *types.Message) error {
+		var payload T
+		meta := newMeta(ctx, msg)
+		if err := decodePayload(msg, meta.Envelope, &payload); err != nil {
+			return Poison(fmt.Errorf("decode %T: %w", payload, err))
+		}
+		return handler(ctx, payload, meta)
+	}
+}
+
+// Handle registers a typed handler on the subscriber
+func Handle[T any](sub *SQSSubscriber, handler func(ctx context.Context, payload T, meta Meta) error) {
+	if handler == nil {
+		return
+	}
+	sub.AddContextHandler(TypedHandler(handler))
+}
+
+// newMeta collects the metadata of msg, including its SNS envelope when not unwrapped yet
+func newMeta(ctx context.Context, msg *types.Message) Meta {
+	meta := Meta{
+		MessageID:    stringValue(msg.MessageId),
+		ReceiveCount: receiveCount(msg),
+		Attributes:   make(map[string]string, len(msg.MessageAttributes)),
+		Message:      msg,
+	}
+
+	if envelope, ok := SNSEnvelopeFromContext(ctx); ok {
+		meta.Envelope = envelope
+	} else if msg.Body != nil {
+		if envelope, err := DecodeSNSEnvelope(*msg.Body); err == nil {
+			meta.Envelope = envelope
+			for name, attr := range envelope.MessageAttributes {
+				meta.Attributes[name] = attr.Value
+			}
+		}
+	}
+
+	for name, attr := range msg.MessageAttributes {
+		if attr.StringValue != nil {
+			meta.Attributes[name] = *attr.StringValue
+		}
+	}
+	return meta
+}
+
+// decodePayload unmarshals the SNS "body" attribute or the message body into dst
+func decodePayload(msg *types.Message, envelope *SNSEnvelope, dst any) error {
+	if body, ok := MessageAttribute(msg, "body"); ok {
+		return json.Unmarshal([]byte(body), dst)
+	}
+
+	body := stringValue(msg.Body)
+	if envelope != nil && body != envelope.Message {
+		// The envelope was not unwrapped by the subscriber
+		body = envelope.Message
+	}
+	if body == "" {
+		return errors.New("empty message body")
+	}
+	return json.Unmarshal([]byte(body), dst)
+}
+
+// receiveCount returns the ApproximateReceiveCount system attribute of msg
+func receiveCount(msg *types.Message) int {
+	count, _ := strconv.Atoi(msg.Attributes[string(types.MessageSystemAttributeNameApproximateReceiveCount)])
+	return count
+}
+
+// handlePoison passes a poison message to the poison handler, deleting it on success.
+// Without a poison handler the message is dead-lettered when a dead-letter path is
+// configured, and otherwise logged and deleted so it is not retried forever
+func (s *SQSSubscriber) handlePoison(ctx context.Context, msg *types.Message, err error) {
+	if s.opts.poisonHandler == nil {
+		if s.opts.deadLetterEnabled() {
+			s.deadLetter(ctx, msg, err)
+			return
+		}
+		log.Printf("Discarding poison message %s: %v", stringValue(msg.MessageId), err)
+		s.deleteMessage(msg)
+		return
+	}
+
+	handlerErr := callRecovered("poison handler", msg, func() error {
+		return s.opts.poisonHandler(ctx, msg, err)
+	})
+	if handlerErr != nil {
+		log.Printf("Error handling poison message %s: %v", stringValue(msg.MessageId), handlerErr)
+		return
+	}
+	s.deleteMessage(msg)
+}